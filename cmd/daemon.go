@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/daemon"
+	"github.com/MRyutaro/rrk/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run the local recording daemon",
+	Long: `Run a daemon that owns the history storage and accepts records from the
+shell hook over a Unix socket in ~/.rrk/. While the daemon is running,
+'rrk hook record' sends entries to it instead of writing the history file
+itself. When the daemon is not running, the hook falls back to direct writes.
+
+The daemon applies ignore rules, redaction and the command size limit, and
+keeps the next ID and recently recorded entries in memory, so the hook does
+not read the config or the history files for each prompt. Changes to
+~/.rrk/config.json, such as ignore rules, audit mode or retention, take
+effect with the next record without restarting the daemon. Each record
+carries an ID, so a command is not stored twice when the daemon saved it
+but its reply did not reach the hook in time.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configPath, err := config.Path()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving config path: %v\n", err)
+			os.Exit(1)
+		}
+
+		socketPath, err := daemon.SocketPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving socket path: %v\n", err)
+			os.Exit(1)
+		}
+
+		// 設定ファイルが変わると読み直すため、除外ルールや監査モード、保持ポリシーの変更に再起動は不要
+		server, err := daemon.Listen(socketPath, configPath, openDaemonBackend)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting daemon: %v\n", err)
+			os.Exit(1)
+		}
+
+		// シグナル受信時にソケットを片付けて終了
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			server.Close()
+		}()
+
+		fmt.Printf("rrk daemon listening on %s\n", socketPath)
+		if err := server.Serve(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running daemon: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the daemon is running",
+	Run: func(cmd *cobra.Command, args []string) {
+		socketPath, err := daemon.SocketPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving socket path: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := daemon.Ping(socketPath); err != nil {
+			fmt.Println("rrk daemon is not running")
+			os.Exit(1)
+		}
+		fmt.Printf("rrk daemon is running on %s\n", socketPath)
+	},
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the running daemon",
+	Run: func(cmd *cobra.Command, args []string) {
+		socketPath, err := daemon.SocketPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving socket path: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := daemon.Stop(socketPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error stopping daemon: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("✅ rrk daemon stopped")
+	},
+}
+
+// openDaemonBackend 設定を読み込み、デーモンが記録に使うStorageとRecorderの設定を作成
+// デーモンは保存のたびにマニフェストやIDカウンタを読み直さないよう、それらをメモリに保持する
+func openDaemonBackend() (*daemon.Backend, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	recorderOpts, err := recorderOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openStorageConfig(cfg, storage.WithWriteCache())
+	if err != nil {
+		return nil, err
+	}
+	return &daemon.Backend{Store: store, Recorder: recorderOpts}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+	daemonCmd.AddCommand(daemonStopCmd)
+}
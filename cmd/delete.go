@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete <id>...",
+	Short: "Delete commands from history by ID",
+	Long: `Delete recorded commands by their IDs, as shown by "rrk search".
+
+Changed history files are written to a temporary file and renamed into
+place, so an interrupted delete never loses the rest of the history.`,
+	Example: `  rrk search --newest -n 1
+  rrk delete 1234
+  rrk delete 1234 1235 1240`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ids := make([]int, 0, len(args))
+		for _, arg := range args {
+			id, err := strconv.Atoi(arg)
+			if err != nil || id <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid command ID %q\n", arg)
+				os.Exit(1)
+			}
+			ids = append(ids, id)
+		}
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		deleted, err := store.Delete(ids...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error deleting commands: %v\n", err)
+			os.Exit(1)
+		}
+
+		if deleted == 0 {
+			fmt.Fprintln(os.Stderr, "Error: no commands with the given IDs were found")
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Deleted %d commands\n", deleted)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deleteCmd)
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// doctorIssueLimit --allを指定しない場合に種類ごとに表示する問題の件数
+const doctorIssueLimit = 10
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check history for corruption and the shell hook for problems",
+	Long: `Check the recorded history and the shell integration.
+
+The history check reports lines that are not valid JSON, duplicate IDs,
+timestamps that go backwards and commands without a working directory,
+each with its history file and line number, and history files that are
+listed in the manifest but missing. With --fix, invalid lines are moved to
+~/.rrk/quarantine.jsonl, duplicate IDs are given new IDs and missing files
+are removed from the manifest.
+
+The shell check reports whether the hook is installed for your shell, is
+active in the current shell, and has recorded commands from it.`,
+	Example: `  rrk doctor
+  rrk doctor --fix`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fix, _ := cmd.Flags().GetBool("fix")
+		all, _ := cmd.Flags().GetBool("all")
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		report, err := store.Check(cmd.Context())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking history: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("History (%d files, %d commands)\n", report.Segments, report.Entries)
+		printIssues(report, storage.IssueMissingSegment, "❌", "Every history file exists",
+			"history files are listed in the manifest but missing", all)
+		printIssues(report, storage.IssueInvalidLine, "❌", "All lines are valid JSON",
+			"lines are not valid JSON", all)
+		printIssues(report, storage.IssueDuplicateID, "❌", "No duplicate IDs",
+			"commands have a duplicate ID", all)
+		printIssues(report, storage.IssueTimeOrder, "⚠️ ", "Timestamps are in order",
+			"commands are older than the command recorded before them", all)
+		printIssues(report, storage.IssueEmptyCWD, "⚠️ ", "Every command has a working directory",
+			"commands have no working directory", all)
+
+		broken := report.Count(storage.IssueInvalidLine) + report.Count(storage.IssueDuplicateID) +
+			report.Count(storage.IssueMissingSegment)
+		if broken > 0 {
+			if fix {
+				result, err := store.Repair(cmd.Context())
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error repairing history: %v\n", err)
+					os.Exit(1)
+				}
+				if result.DroppedSegments > 0 {
+					fmt.Printf("✅ Removed %d missing history files from the manifest\n", result.DroppedSegments)
+				}
+				if result.Quarantined > 0 {
+					fmt.Printf("✅ Moved %d invalid lines to %s\n", result.Quarantined, store.QuarantineFile())
+				}
+				if result.Renumbered > 0 {
+					fmt.Printf("✅ Gave new IDs to %d commands\n", result.Renumbered)
+				}
+				broken = 0
+			} else {
+				fmt.Println("   Run \"rrk doctor --fix\" to quarantine invalid lines, renumber duplicate IDs and drop missing files from the manifest.")
+			}
+		}
+
+		fmt.Println()
+		fmt.Println("Shell integration")
+		checkShellIntegration(cmd, store)
+
+		if broken > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// printIssues 指定した種類の問題を件数と位置とともに表示（allでなければ先頭の一部のみ）
+func printIssues(report *storage.CheckReport, kind storage.IssueKind, mark, ok, description string, all bool) {
+	count := report.Count(kind)
+	if count == 0 {
+		fmt.Printf("✅ %s\n", ok)
+		return
+	}
+
+	fmt.Printf("%s %d %s\n", mark, count, description)
+	shown := 0
+	for _, issue := range report.Issues {
+		if issue.Kind != kind {
+			continue
+		}
+		if !all && shown == doctorIssueLimit {
+			fmt.Printf("   ... and %d more (use --all to list them)\n", count-shown)
+			return
+		}
+		location := issue.Segment
+		if issue.Line != 0 {
+			location += fmt.Sprintf(":%d", issue.Line)
+		}
+		if issue.ID != 0 {
+			location += fmt.Sprintf(" (ID %d)", issue.ID)
+		}
+		fmt.Printf("   %s: %s\n", location, issue.Detail)
+		shown++
+	}
+}
+
+// checkShellIntegration フックがインストールされ、このシェルで動作して記録しているかを表示
+func checkShellIntegration(cmd *cobra.Command, store *storage.Storage) {
+	shell := detectShell()
+	homeDir, err := os.UserHomeDir()
+	switch {
+	case shell == "":
+		fmt.Println("⚠️  Could not detect a supported shell from $SHELL")
+	case err != nil:
+		fmt.Printf("⚠️  Could not check the %s configuration: %v\n", shell, err)
+	default:
+		configFile := shellConfigPath(homeDir, shell)
+		if isAlreadyConfigured(configFile) {
+			fmt.Printf("✅ Hook is installed in %s\n", configFile)
+		} else {
+			fmt.Printf("❌ Hook is not installed in %s (run \"rrk setup\")\n", configFile)
+		}
+	}
+
+	// フックはシェルの起動時にセッションIDとシェルのPIDを設定する
+	sessionID := os.Getenv("RRK_SESSION_ID")
+	if sessionID == "" || sessionID == "unknown" {
+		fmt.Println("❌ Hook is not active in this shell (restart the shell after \"rrk setup\")")
+		return
+	}
+	if pid := os.Getenv("RRK_SESSION_PID"); pid != strconv.Itoa(os.Getppid()) {
+		fmt.Println("⚠️  Hook was started by a parent shell but is not active in this shell")
+		return
+	}
+	fmt.Printf("✅ Hook is active in this shell (session %s)\n", sessionID)
+
+	var last *history.Entry
+	filter := history.EntryFilter{SessionID: &sessionID, Limit: 1}
+	err = store.IterateReverse(cmd.Context(), filter, func(entry history.Entry) error {
+		last = &entry
+		return nil
+	})
+	switch {
+	case err != nil:
+		fmt.Printf("⚠️  Could not read commands from this shell: %v\n", err)
+	case last == nil:
+		fmt.Println("⚠️  No commands have been recorded from this shell yet (run a command and check again)")
+	default:
+		age := time.Since(last.Timestamp.Add(last.Duration)).Round(time.Second)
+		fmt.Printf("✅ Last command from this shell was recorded %s ago: %s\n", age, formatSearchCommand(*last))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().Bool("fix", false, "Quarantine invalid lines and give duplicate IDs new IDs")
+	doctorCmd.Flags().Bool("all", false, "List every problem instead of the first few of each kind")
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/MRyutaro/rrk/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var editCmd = &cobra.Command{
+	Use:   "edit <id>",
+	Short: "Edit a recorded command in $EDITOR",
+	Long: `Open a recorded command in $VISUAL or $EDITOR so that a password or
+other secret can be removed from it. The edited command replaces the
+recorded one when the editor exits; leave it unchanged to cancel.
+
+Changed history files are written to a temporary file and renamed into
+place, so an interrupted edit never loses the rest of the history.`,
+	Example: `  rrk edit 1234
+  EDITOR=nano rrk edit 1234`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil || id <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid command ID %q\n", args[0])
+			os.Exit(1)
+		}
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		entry, err := store.GetByID(id)
+		if errors.Is(err, storage.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "Error: command %d not found\n", id)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading history: %v\n", err)
+			os.Exit(1)
+		}
+
+		edited, err := editInEditor(entry.Command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error editing command: %v\n", err)
+			os.Exit(1)
+		}
+
+		switch {
+		case edited == entry.Command:
+			fmt.Println("No changes.")
+			return
+		case strings.TrimSpace(edited) == "":
+			fmt.Fprintf(os.Stderr, "Error: the edited command is empty; use \"rrk delete %d\" to delete it\n", id)
+			os.Exit(1)
+		}
+
+		entry.Command = edited
+		if err := store.Replace(*entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving command: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Updated command %d\n", id)
+	},
+}
+
+// editInEditor 一時ファイルに書き出したテキストをエディタで編集し、結果を返す
+// 一時ファイルは本人のみ読み書きでき、編集後すぐに削除する
+func editInEditor(text string) (string, error) {
+	file, err := os.CreateTemp("", "rrk-edit-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	path := file.Name()
+	defer os.Remove(path)
+
+	_, err = file.WriteString(text + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	// "code --wait" のように引数付きで指定されたエディタにも対応
+	editor := strings.Fields(editorCommand())
+	command := exec.Command(editor[0], append(editor[1:], path)...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("editor %s failed: %w", editor[0], err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read edited command: %w", err)
+	}
+	// エディタが付け足す末尾の改行は取り除く
+	edited := strings.TrimSuffix(string(data), "\n")
+	edited = strings.TrimSuffix(edited, "\r")
+	return edited, nil
+}
+
+// editorCommand $VISUAL、$EDITOR の順にエディタを決める（未設定ならOS標準のエディタ）
+func editorCommand() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.TrimSpace(os.Getenv(name)); editor != "" {
+			return editor
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
+func init() {
+	rootCmd.AddCommand(editCmd)
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/MRyutaro/rrk/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var encryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt recorded history",
+	Long: `Encrypt the recorded history with AES-GCM and keep encrypting new commands.
+
+By default a random key is stored in ~/.rrk/key, readable only by you.
+Set "key_file" in ~/.rrk/config.json to keep the key somewhere else.
+With --passphrase, the key is derived from the passphrase in $RRK_PASSPHRASE
+instead, and every shell that records or reads history needs it set. The
+derived key is cached in $XDG_RUNTIME_DIR/rrk until you log out, so shells
+that miss $RRK_PASSPHRASE keep recording while the cache exists.
+
+Existing history files are rewritten one at a time through a temporary
+file, so an interrupted encrypt can simply be run again.`,
+	Example: `  rrk encrypt
+  RRK_PASSPHRASE='correct horse battery staple' rrk encrypt --passphrase`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		usePassphrase, _ := cmd.Flags().GetBool("passphrase")
+		source := storage.KeySourceFile
+		if usePassphrase {
+			if os.Getenv(storage.PassphraseEnv) == "" {
+				fmt.Fprintf(os.Stderr, "Error: set the passphrase in $%s\n", storage.PassphraseEnv)
+				os.Exit(1)
+			}
+			source = storage.KeySourcePassphrase
+		}
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := store.Encrypt(source); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encrypting history: %v\n", err)
+			os.Exit(1)
+		}
+
+		if usePassphrase {
+			fmt.Printf("✅ History encrypted with the passphrase in $%s\n", storage.PassphraseEnv)
+			fmt.Printf("   Export %s in your shell configuration so new commands can be recorded.\n", storage.PassphraseEnv)
+		} else {
+			fmt.Printf("✅ History encrypted with the key in %s\n", store.KeyFile())
+			fmt.Println("   Back up this key: the history cannot be read without it.")
+		}
+	},
+}
+
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Decrypt recorded history",
+	Long: `Decrypt the recorded history and store new commands unencrypted again.
+
+The key file or $RRK_PASSPHRASE used by "rrk encrypt" is needed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := store.Decrypt(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error decrypting history: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("✅ History decrypted")
+		if _, err := os.Stat(store.KeyFile()); err == nil {
+			fmt.Printf("   The key in %s is no longer needed.\n", store.KeyFile())
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(encryptCmd)
+	rootCmd.AddCommand(decryptCmd)
+	encryptCmd.Flags().Bool("passphrase", false, "Derive the key from $RRK_PASSPHRASE instead of a key file")
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export recorded commands as JSON Lines",
+	Long: `Write the recorded commands that match the filter flags as JSON Lines,
+one entry per line. The output can be read back with 'rrk import json'.`,
+	Example: `  rrk export > history.jsonl
+  rrk export --dir ~/src/app --since 30d -o app.jsonl`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filter, err := filterFromFlags(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		out := os.Stdout
+		if path, _ := cmd.Flags().GetString("output"); path != "" && path != "-" {
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		writer := bufio.NewWriter(out)
+		encoder := json.NewEncoder(writer)
+		count := 0
+		err = store.Iterate(cmd.Context(), filter, func(entry history.Entry) error {
+			count++
+			return encoder.Encode(&entry)
+		})
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error exporting history: %v\n", err)
+			os.Exit(1)
+		}
+
+		if out != os.Stdout {
+			fmt.Fprintf(os.Stderr, "✅ Exported %d commands\n", count)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	addFilterFlags(exportCmd)
+	addPagingFlags(exportCmd)
+	exportCmd.Flags().StringP("output", "o", "", "File to write to (default: standard output)")
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/session"
+	"github.com/spf13/cobra"
+)
+
+// addFilterFlags tree・search・exportで共通の絞り込みフラグを追加
+func addFilterFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.String("since", "", "Show only commands run at or after this time (e.g. 2h, 7d, 2024-05-01)")
+	flags.String("until", "", "Show only commands run before this time (e.g. 1d, 2024-05-01T12:00:00Z)")
+	flags.String("dir", "", "Show only commands run in this directory or below it")
+	flags.String("contains", "", "Show only commands containing this text")
+	flags.String("regex", "", "Show only commands matching this regular expression")
+	flags.Bool("failed", false, "Show only commands that exited with a non-zero status")
+	flags.Int("exit", 0, "Show only commands that exited with this status")
+	flags.Duration("slow", 0, "Show only commands that took at least this long (e.g. 5s)")
+	flags.String("repo", "", "Show only commands run inside the Git repository rooted at this path")
+	flags.String("branch", "", "Show only commands run on this Git branch")
+	flags.String("host", "", "Show only commands recorded on this host")
+	flags.String("user", "", "Show only commands recorded by this user")
+	flags.String("shell", "", "Show only commands recorded from this shell (bash, zsh or fish)")
+	flags.String("tty", "", "Show only commands recorded on this terminal device")
+	flags.String("tmux-pane", "", "Show only commands recorded in this tmux pane (e.g. %3)")
+	flags.String("session-name", "", "Show only commands from sessions with this name and the shells started from them")
+}
+
+// addPagingFlags 一覧を出力するコマンド用の件数・順序のフラグを追加
+func addPagingFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.IntP("limit", "n", 0, "Maximum number of commands to output (0 = all)")
+	flags.Int("offset", 0, "Number of matching commands to skip")
+	flags.Bool("newest", false, "Output the newest commands first (--limit and --offset count from the newest)")
+}
+
+// filterFromFlags addFilterFlags（とaddPagingFlags）で追加したフラグから絞り込み条件を組み立てる
+func filterFromFlags(cmd *cobra.Command) (history.EntryFilter, error) {
+	flags := cmd.Flags()
+	var filter history.EntryFilter
+
+	filter.Failed, _ = flags.GetBool("failed")
+	filter.MinDuration, _ = flags.GetDuration("slow")
+	filter.CommandContains, _ = flags.GetString("contains")
+	if flags.Lookup("limit") != nil {
+		filter.Limit, _ = flags.GetInt("limit")
+		filter.Offset, _ = flags.GetInt("offset")
+		filter.Newest, _ = flags.GetBool("newest")
+	}
+
+	now := time.Now()
+	for flag, target := range map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	} {
+		if value, _ := flags.GetString(flag); value != "" {
+			t, err := parseTimeFlag(value, now)
+			if err != nil {
+				return filter, fmt.Errorf("invalid --%s: %w", flag, err)
+			}
+			*target = t
+		}
+	}
+
+	// パスは絶対パスに変換して比較
+	for flag, target := range map[string]**string{
+		"dir":  &filter.CWDPrefix,
+		"repo": &filter.RepoRoot,
+	} {
+		if value, _ := flags.GetString(flag); value != "" {
+			path, err := filepath.Abs(value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid --%s: %w", flag, err)
+			}
+			*target = &path
+		}
+	}
+
+	if pattern, _ := flags.GetString("regex"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return filter, fmt.Errorf("invalid --regex: %w", err)
+		}
+		filter.CommandRegex = re
+	}
+
+	if flags.Changed("exit") {
+		exitCode, _ := flags.GetInt("exit")
+		filter.ExitCode = &exitCode
+	}
+
+	for flag, target := range map[string]**string{
+		"branch":    &filter.Branch,
+		"host":      &filter.Hostname,
+		"user":      &filter.Username,
+		"shell":     &filter.Shell,
+		"tty":       &filter.TTY,
+		"tmux-pane": &filter.TmuxPane,
+	} {
+		if flags.Changed(flag) {
+			value, _ := flags.GetString(flag)
+			*target = &value
+		}
+	}
+
+	if flags.Changed("session-name") {
+		name, _ := flags.GetString("session-name")
+		ids, err := session.FindByName(name)
+		if err != nil {
+			return filter, fmt.Errorf("failed to read sessions: %w", err)
+		}
+		filter.SessionIDs = ids
+	}
+
+	return filter, nil
+}
+
+// filterFlagsChanged 絞り込みフラグが1つでも指定されたかを返す
+func filterFlagsChanged(cmd *cobra.Command) bool {
+	for _, flag := range []string{"since", "until", "dir", "contains", "regex", "failed", "exit", "slow",
+		"repo", "branch", "host", "user", "shell", "tty", "tmux-pane", "session-name"} {
+		if cmd.Flags().Changed(flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTimeFlag "2h" や "7d" のような経過時間、または日付・日時を時刻に変換
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if age, err := config.ParseAge(value); err == nil {
+		return now.Add(-age), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration (e.g. 2h, 7d) nor a date (e.g. 2024-05-01)", value)
+}
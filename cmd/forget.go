@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"text/tabwriter"
+
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/spf13/cobra"
+)
+
+var forgetCmd = &cobra.Command{
+	Use:   "forget <pattern>",
+	Short: "Delete every command that contains a pattern",
+	Long: `Delete every recorded command that contains the given text, or matches
+it as a regular expression with --regex.
+
+The matching commands are listed first and deleted only after confirmation.
+Use --dry-run to only list them, or --yes to skip the confirmation.`,
+	Example: `  rrk forget 'hunter2'
+  rrk forget --regex 'mysql .*-p\S+' --dry-run`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		useRegex, _ := cmd.Flags().GetBool("regex")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		autoConfirm, _ := cmd.Flags().GetBool("yes")
+
+		var filter history.EntryFilter
+		if useRegex {
+			re, err := regexp.Compile(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid pattern: %v\n", err)
+				os.Exit(1)
+			}
+			filter.CommandRegex = re
+		} else {
+			filter.CommandContains = args[0]
+		}
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		// 削除対象を search と同じ形式で一覧表示
+		homeDir, _ := os.UserHomeDir()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		var ids []int
+		err = store.Iterate(cmd.Context(), filter, func(entry history.Entry) error {
+			ids = append(ids, entry.ID)
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", entry.ID, entry.Timestamp.Local().Format("2006-01-02 15:04:05"),
+				shortenHome(entry.CWD, homeDir), formatSearchCommand(entry))
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error searching history: %v\n", err)
+			os.Exit(1)
+		}
+		w.Flush()
+
+		switch {
+		case len(ids) == 0:
+			fmt.Println("No matching commands.")
+			return
+		case dryRun:
+			fmt.Printf("%d commands would be forgotten (dry run).\n", len(ids))
+			return
+		}
+
+		if !autoConfirm {
+			fmt.Printf("Delete these %d commands from history? [y/N]: ", len(ids))
+			var response string
+			if _, err := fmt.Scanln(&response); err != nil || (response != "y" && response != "Y" && response != "yes") {
+				fmt.Println("Forget cancelled.")
+				return
+			}
+		}
+
+		// 一覧表示した後に記録されたコマンドは対象にしないよう、IDで削除する
+		deleted, err := store.Delete(ids...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error deleting commands: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Forgot %d commands\n", deleted)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(forgetCmd)
+	forgetCmd.Flags().Bool("regex", false, "Treat the pattern as a regular expression")
+	forgetCmd.Flags().Bool("dry-run", false, "Only list the commands that would be deleted")
+	forgetCmd.Flags().BoolP("yes", "y", false, "Delete without asking for confirmation")
+}
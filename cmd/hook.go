@@ -1,11 +1,20 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/daemon"
+	"github.com/MRyutaro/rrk/internal/ignore"
+	"github.com/MRyutaro/rrk/internal/paths"
+	"github.com/MRyutaro/rrk/internal/recorder"
+	"github.com/MRyutaro/rrk/internal/redact"
 	"github.com/MRyutaro/rrk/internal/session"
 	"github.com/MRyutaro/rrk/internal/storage"
 	"github.com/spf13/cobra"
@@ -22,120 +31,382 @@ var hookRecordCmd = &cobra.Command{
 	Short: "Record a command to history",
 	Args:  cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		store, err := storage.New()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
-			os.Exit(1)
+		// 全ての引数を結合して完全なコマンドを作成
+		c := recorder.Command{Text: strings.Join(args, " ")}
+		c.CWD, _ = cmd.Flags().GetString("cwd")
+		c.TTY, _ = cmd.Flags().GetString("tty")
+		c.Shell, _ = cmd.Flags().GetString("shell")
+		c.ShellVersion, _ = cmd.Flags().GetString("shell-version")
+		if cmd.Flags().Changed("exit-code") {
+			exitCode, _ := cmd.Flags().GetInt("exit-code")
+			c.ExitCode = &exitCode
+		}
+		if start, _ := cmd.Flags().GetString("start"); start != "" {
+			// 開始時刻が読めなくてもコマンド自体は記録する（実行時間だけを諦める）
+			startedAt, err := parseEpoch(start)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: ignoring start time: %v\n", err)
+			} else {
+				c.Start = startedAt
+			}
+		}
+		if cmd.Flags().Changed("duration") {
+			durationMs, _ := cmd.Flags().GetInt64("duration")
+			duration := time.Duration(durationMs) * time.Millisecond
+			c.Duration = &duration
 		}
 
-		sessionID, err := session.GetCurrentSessionID()
+		req, err := daemon.NewRequest(c)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting session ID: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error recording command: %v\n", err)
 			os.Exit(1)
 		}
 
-		cwd, err := os.Getwd()
+		// デーモンが起動していれば、設定を読まずにソケット経由で記録（除外ルールなどはデーモンが適用する）
+		if socketPath, err := daemon.SocketPath(); err == nil {
+			if _, err := daemon.Record(socketPath, req); err == nil {
+				return
+			}
+		}
+
+		// デーモンがいない場合は設定を読み込み、ファイルに直接書き込む
+		// デーモンと同じ値でエントリを作るため、応答が届かなかった要求を保存し直しても重複しない
+		cfg := loadConfig()
+		recorderOpts, err := recorderOptions(cfg)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 			os.Exit(1)
 		}
+		rec := recorder.New(append(recorderOpts, req.Options()...)...)
 
-		// 全ての引数を結合して完全なコマンドを作成
-		command := ""
-		for i, arg := range args {
-			if i > 0 {
-				command += " "
-			}
-			command += arg
+		entry, err := rec.Entry(req.Command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error recording command: %v\n", err)
+			os.Exit(1)
 		}
-
-		// 要件に従ってcdコマンドの記録をスキップ
-		if len(command) >= 2 && command[:2] == "cd" {
+		if entry == nil {
+			// 除外ルールに一致した
 			return
 		}
+		entry.RecordID = req.RecordID
 
-		entry := &history.Entry{
-			SessionID: sessionID,
-			CWD:       cwd,
-			Command:   command,
-			Timestamp: time.Now(),
+		store, err := openStorageConfig(cfg)
+		if err != nil {
+			warnNotRecorded(req, err)
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
 		}
 
 		if err := store.Save(entry); err != nil {
+			warnNotRecorded(req, err)
 			fmt.Fprintf(os.Stderr, "Error saving history: %v\n", err)
 			os.Exit(1)
 		}
 	},
 }
 
+// warnNotRecorded RRK_PASSPHRASEがないためにコマンドを記録できなかった場合、セッションごとに一度だけ端末に警告を表示する
+// シェル統合はフックの標準エラー出力を捨てるため、端末に直接書き込まないと記録されていないことに気付けない
+func warnNotRecorded(req *daemon.Request, err error) {
+	if !errors.Is(err, storage.ErrNoPassphrase) {
+		return
+	}
+
+	// 警告済みのセッションは印のファイルで判定する（作成できなければ毎回警告する）
+	sessionID := req.SessionID
+	if sessionID == "" || strings.ContainsAny(sessionID, `/\.`) {
+		sessionID = "unknown"
+	}
+	if dir, dirErr := paths.RuntimeDir(); dirErr == nil {
+		marker, createErr := os.OpenFile(filepath.Join(dir, "warned-passphrase-"+sessionID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if os.IsExist(createErr) {
+			return
+		}
+		if createErr == nil {
+			marker.Close()
+		}
+	}
+
+	out := os.Stderr
+	tty := req.Command.TTY
+	if tty == "" {
+		tty = "/dev/tty"
+	}
+	if f, openErr := os.OpenFile(tty, os.O_WRONLY, 0); openErr == nil {
+		defer f.Close()
+		out = f
+	}
+	fmt.Fprintf(out, "rrk: commands are not being recorded: %v (shown once per session)\n", err)
+}
+
+// recorderOptions 設定の除外ルール・伏せ字化・切り詰めを適用するRecorderの設定を返す
+func recorderOptions(cfg config.Config) ([]recorder.Option, error) {
+	matcher, err := ignore.New(cfg.Ignore)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignore rules: %w", err)
+	}
+	redactor, err := redact.New(cfg.Redact)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redact patterns: %w", err)
+	}
+	return []recorder.Option{
+		recorder.WithIgnore(matcher),
+		recorder.WithRedactor(redactor),
+		recorder.WithMaxCommandBytes(cfg.MaxCommandBytes),
+	}, nil
+}
+
+// parseEpoch シェルが出力するUNIX時刻（小数秒を含む）をパース
+// bashの$EPOCHREALTIMEはロケールの小数点を使うため、"1700000000,5" のようなカンマも受け付ける
+func parseEpoch(value string) (time.Time, error) {
+	seconds, err := strconv.ParseFloat(strings.Replace(value, ",", ".", 1), 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid epoch time %q", value)
+	}
+	sec := int64(seconds)
+	nsec := int64((seconds - float64(sec)) * float64(time.Second))
+	return time.Unix(sec, nsec), nil
+}
+
 var hookInitCmd = &cobra.Command{
 	Use:   "init <shell>",
 	Short: "Initialize shell integration",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		shell := args[0]
-		switch shell {
-		case "bash":
-			fmt.Print(bashHook())
-		case "zsh":
-			fmt.Print(zshHook())
-		default:
-			fmt.Fprintf(os.Stderr, "Unsupported shell: %s\n", shell)
+		script, err := hookScript(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
+		fmt.Print(script)
 	},
 }
 
+// hookScript シェル統合スクリプトを返す
+// --data-dir・RRK_HOME や --profile・RRK_PROFILE が指定されていれば、フックから起動するrrkが同じ履歴に記録するよう
+// スクリプトの先頭でその値を設定する（シェルですでに設定されていればそちらを優先する）
+func hookScript(shell string) (string, error) {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashHook()
+	case "zsh":
+		script = zshHook()
+	case "fish":
+		script = fishHook()
+	default:
+		return "", fmt.Errorf("unsupported shell: %s", shell)
+	}
+
+	dataDir, err := paths.ExplicitRootDir()
+	if err != nil {
+		return "", err
+	}
+	profile, err := paths.ExplicitProfile()
+	if err != nil {
+		return "", err
+	}
+
+	var env strings.Builder
+	for _, v := range []struct{ name, value string }{{paths.HomeEnv, dataDir}, {paths.ProfileEnv, profile}} {
+		if v.value == "" {
+			continue
+		}
+		if shell == "fish" {
+			fmt.Fprintf(&env, "set -q %s; or set -gx %s %s\n", v.name, v.name, fishQuote(v.value))
+		} else {
+			fmt.Fprintf(&env, "[ -n \"$%s\" ] || export %s=%s\n", v.name, v.name, shellQuote(v.value))
+		}
+	}
+	if env.Len() == 0 {
+		return script, nil
+	}
+
+	// 先頭のコメント行の後に挿入する
+	header, body, _ := strings.Cut(script, "\n")
+	return header + "\n" + env.String() + body, nil
+}
+
+// shellQuote bash・zshのシングルクォートで囲んだ文字列を返す
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// fishQuote fishのシングルクォートで囲んだ文字列を返す
+func fishQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
+
 func bashHook() string {
 	return `# rrk shell integration for bash
+# コマンド開始時刻をDEBUGトラップで記録（プロンプト表示後の最初のコマンドのみ）
+_rrk_preexec() {
+    # PROMPT_COMMAND自体の実行は無視（空行のEnterでもここに来る）
+    [ -n "$_rrk_in_prompt" ] && return
+    [ "$BASH_COMMAND" = "_rrk_save_status" ] && return
+    if [ -n "$_rrk_armed" ]; then
+        _rrk_armed=
+        _rrk_start=${EPOCHREALTIME:-$(date +%s)}
+        _rrk_pwd=$PWD
+    fi
+}
+
+_rrk_save_status() {
+    _rrk_status=$?
+    _rrk_in_prompt=1
+}
+
 _rrk_hook() {
-    local exit_code=$?
-    local command=$(history 1 | sed 's/^[ ]*[0-9]*[ ]*//')
-    if [ -n "$command" ]; then
-        rrk hook record "$command" 2>/dev/null || true
+    local exit_code=$_rrk_status
+    # 履歴の最終行が変わった時だけ記録（空行のEnterや、HISTCONTROLで履歴に残らなかった
+    # コマンドでは直前の行が返る）。サブシェル "( ... )" ではDEBUGトラップが動かないため、
+    # 開始時刻がなくても記録する
+    local line=$(HISTTIMEFORMAT= builtin history 1)
+    if [ -n "$line" ] && [ "$line" != "$_rrk_last_line" ]; then
+        _rrk_last_line=$line
+        # 行番号を除去（先頭の空白はignorespace判定のため残す）
+        local command=${line#*[0-9][* ] }
+        local args=(--exit-code "$exit_code" --shell bash --shell-version "$BASH_VERSION"
+            --tty "$_rrk_tty" --cwd "${_rrk_pwd:-$PWD}")
+        [ -n "$_rrk_start" ] && args+=(--start "$_rrk_start")
+        rrk hook record "${args[@]}" -- "$command" 2>/dev/null || true
     fi
+    _rrk_start=
+    _rrk_pwd=
+    _rrk_armed=1
+    _rrk_in_prompt=
     return $exit_code
 }
 
+_rrk_session_end() {
+    # サブシェルの終了では記録しない
+    [ "${BASHPID:-$$}" = "$RRK_SESSION_PID" ] && rrk hook session-end 2>/dev/null
+}
+
+_rrk_install_exit_trap() {
+    # 既存のEXITトラップがあれば、その後に実行する
+    eval "set -- $(trap -p EXIT)"
+    if [ -z "$3" ]; then
+        trap '_rrk_session_end' EXIT
+    elif [[ "$3" != *"_rrk_session_end"* ]]; then
+        trap -- "$3"'; _rrk_session_end' EXIT
+    fi
+}
+
 # Set up the hook
-if [ -z "$RRK_SESSION_ID" ]; then
-    export RRK_SESSION_ID=$(rrk hook session-init 2>/dev/null || echo "unknown")
+[ -t 0 ] && _rrk_tty=$(tty)
+# 親シェルから引き継いだIDの場合は、親を記録して新しいセッションを始める
+if [ -z "$RRK_SESSION_ID" ] || [ "$RRK_SESSION_PID" != "$$" ]; then
+    export RRK_SESSION_ID=$(rrk hook session-init --shell bash --pid $$ --parent "$RRK_SESSION_ID" 2>/dev/null || echo "unknown")
+    export RRK_SESSION_PID=$$
 fi
 
 # Install the hook
+_rrk_install_exit_trap
 if [[ "$PROMPT_COMMAND" != *"_rrk_hook"* ]]; then
-    PROMPT_COMMAND="${PROMPT_COMMAND:+$PROMPT_COMMAND; }_rrk_hook"
+    PROMPT_COMMAND="_rrk_save_status${PROMPT_COMMAND:+; $PROMPT_COMMAND}; _rrk_hook"
 fi
+trap '_rrk_preexec' DEBUG
+_rrk_last_line=$(HISTTIMEFORMAT= builtin history 1)
+_rrk_armed=1
 `
 }
 
 func zshHook() string {
 	return `# rrk shell integration for zsh
+zmodload zsh/datetime 2>/dev/null
+
+_rrk_preexec() {
+    _rrk_command=$1
+    _rrk_start=${EPOCHREALTIME:-$EPOCHSECONDS}
+    _rrk_pwd=$PWD
+}
+
 _rrk_hook() {
     local exit_code=$?
-    local command=$(fc -ln -1)
-    if [ -n "$command" ]; then
-        rrk hook record "$command" 2>/dev/null || true
+    if [ -n "$_rrk_start" ] && [ -n "$_rrk_command" ]; then
+        rrk hook record --exit-code "$exit_code" --start "$_rrk_start" \
+            --shell zsh --shell-version "$ZSH_VERSION" --tty "$_rrk_tty" --cwd "$_rrk_pwd" -- "$_rrk_command" 2>/dev/null || true
     fi
+    unset _rrk_command _rrk_start _rrk_pwd
     return $exit_code
 }
 
+_rrk_session_end() {
+    rrk hook session-end 2>/dev/null
+}
+
 # Set up the hook
-if [ -z "$RRK_SESSION_ID" ]; then
-    export RRK_SESSION_ID=$(rrk hook session-init 2>/dev/null || echo "unknown")
+[ -t 0 ] && _rrk_tty=$(tty)
+# 親シェルから引き継いだIDの場合は、親を記録して新しいセッションを始める
+if [ -z "$RRK_SESSION_ID" ] || [ "$RRK_SESSION_PID" != "$$" ]; then
+    export RRK_SESSION_ID=$(rrk hook session-init --shell zsh --pid $$ --parent "$RRK_SESSION_ID" 2>/dev/null || echo "unknown")
+    export RRK_SESSION_PID=$$
 fi
 
 # Install the hook
 autoload -U add-zsh-hook
+add-zsh-hook preexec _rrk_preexec
 add-zsh-hook precmd _rrk_hook
+add-zsh-hook zshexit _rrk_session_end
 `
 }
 
+func fishHook() string {
+	return `# rrk shell integration for fish
+function _rrk_preexec --on-event fish_preexec
+    set -g _rrk_pwd $PWD
+end
+
+function _rrk_postexec --on-event fish_postexec
+    set -l exit_code $status
+    if test -n "$argv[1]"
+        rrk hook record --exit-code $exit_code --duration $CMD_DURATION \
+            --shell fish --shell-version $version --tty "$_rrk_tty" --cwd "$_rrk_pwd" -- $argv[1] 2>/dev/null; or true
+    end
+end
+
+function _rrk_session_end --on-event fish_exit
+    rrk hook session-end 2>/dev/null
+end
+
+# Set up the hook
+isatty stdin; and set -g _rrk_tty (tty)
+# 親シェルから引き継いだIDの場合は、親を記録して新しいセッションを始める
+if not set -q RRK_SESSION_ID; or test "$RRK_SESSION_PID" != "$fish_pid"
+    set -l parent $RRK_SESSION_ID
+    set -gx RRK_SESSION_ID (rrk hook session-init --shell fish --pid $fish_pid --parent "$parent" 2>/dev/null; or echo "unknown")
+    set -gx RRK_SESSION_PID $fish_pid
+end
+`
+}
+
+var hookSessionEndCmd = &cobra.Command{
+	Use:   "session-end",
+	Short: "Record the end of the current session",
+	Run: func(cmd *cobra.Command, args []string) {
+		sessionID := os.Getenv("RRK_SESSION_ID")
+		if sessionID == "" || sessionID == "unknown" {
+			return
+		}
+
+		if err := session.End(sessionID, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error ending session: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
 var hookSessionInitCmd = &cobra.Command{
 	Use:   "session-init",
 	Short: "Initialize a new session",
 	Run: func(cmd *cobra.Command, args []string) {
-		sessionID, err := session.InitializeSession()
+		shell, _ := cmd.Flags().GetString("shell")
+		parent, _ := cmd.Flags().GetString("parent")
+		pid, _ := cmd.Flags().GetInt("pid")
+
+		sessionID, err := session.InitializeSession(shell, parent, pid)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error initializing session: %v\n", err)
 			os.Exit(1)
@@ -149,4 +420,17 @@ func init() {
 	hookCmd.AddCommand(hookRecordCmd)
 	hookCmd.AddCommand(hookInitCmd)
 	hookCmd.AddCommand(hookSessionInitCmd)
+	hookCmd.AddCommand(hookSessionEndCmd)
+
+	hookRecordCmd.Flags().Int("exit-code", 0, "Exit status of the recorded command")
+	hookRecordCmd.Flags().String("start", "", "Start time of the recorded command in UNIX seconds (fractions allowed)")
+	hookRecordCmd.Flags().Int64("duration", 0, "Duration of the recorded command in milliseconds")
+	hookRecordCmd.Flags().String("shell", "", "Name of the shell that ran the command")
+	hookRecordCmd.Flags().String("shell-version", "", "Version of the shell that ran the command")
+	hookRecordCmd.Flags().String("cwd", "", "Directory the command was started in (defaults to the current directory)")
+	hookRecordCmd.Flags().String("tty", "", "Terminal device of the shell (defaults to $TTY)")
+
+	hookSessionInitCmd.Flags().String("shell", "", "Name of the shell starting the session")
+	hookSessionInitCmd.Flags().String("parent", "", "ID of the session this one was started from")
+	hookSessionInitCmd.Flags().Int("pid", 0, "Process ID of the shell (defaults to the parent process)")
 }
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MRyutaro/rrk/internal/ignore"
+	"github.com/spf13/cobra"
+)
+
+var ignoreCmd = &cobra.Command{
+	Use:   "ignore",
+	Short: "Inspect the rules that keep commands out of history",
+	Long: `Inspect the ignore rules defined in ~/.rrk/config.json.
+
+Rules can match the first word of a command exactly ("words"), the whole
+command with a glob ("globs") or any part of it with a regular expression
+("regexes"). With "ignore_space" enabled, commands typed with a leading
+space are not recorded, like HISTCONTROL=ignorespace.`,
+}
+
+var ignoreTestCmd = &cobra.Command{
+	Use:   "test <command>",
+	Short: "Show which ignore rule would match a command",
+	Args:  cobra.MinimumNArgs(1),
+	// テスト対象のコマンドに含まれるフラグをそのまま受け取る
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		matcher := loadIgnoreMatcher()
+
+		// フックと同じく、先頭のcdを除いた部分で判定する
+		command := strings.Join(args, " ")
+		cwd, _ := os.Getwd()
+		homeDir, _ := os.UserHomeDir()
+		if rule, ignored := matcher.MatchCommand(command, cwd, homeDir); ignored {
+			fmt.Printf("ignored by %s\n", rule)
+			return
+		}
+		fmt.Println("not ignored (would be recorded)")
+	},
+}
+
+var ignoreListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the active ignore rules in evaluation order",
+	Run: func(cmd *cobra.Command, args []string) {
+		matcher := loadIgnoreMatcher()
+
+		rules := matcher.Rules()
+		if len(rules) == 0 {
+			fmt.Println("No ignore rules configured.")
+			return
+		}
+		for _, rule := range rules {
+			fmt.Println(rule)
+		}
+	},
+}
+
+// loadIgnoreMatcher 設定ファイルから除外ルールを読み込む
+func loadIgnoreMatcher() *ignore.Matcher {
+	cfg := loadConfig()
+
+	matcher, err := ignore.New(cfg.Ignore)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading ignore rules: %v\n", err)
+		os.Exit(1)
+	}
+	return matcher
+}
+
+func init() {
+	rootCmd.AddCommand(ignoreCmd)
+	ignoreCmd.AddCommand(ignoreTestCmd)
+	ignoreCmd.AddCommand(ignoreListCmd)
+}
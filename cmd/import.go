@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/importer"
+	"github.com/spf13/cobra"
+)
+
+// importedSessionID 取り込んだエントリに付けるセッションID
+const importedSessionID = "imported"
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import existing shell history",
+	Long: `Import commands from existing shell history files into rrk.
+
+Imported commands keep their original timestamps and are stored in the
+"imported" session. Shell history files do not record the directory a
+command ran in, so imported commands have no directory unless --cwd is
+given. JSON and CSV exports keep the directory, exit status and duration
+of each command when they provide them. Running the same import again
+does not create duplicates.
+
+Each command is stored with the history of the month it ran in, so
+retention settings apply to it like to recorded commands. Lines without a
+timestamp (bash without HISTTIMEFORMAT) are told apart by how often the
+same command appeared before them, so repeated commands are all imported,
+but importing again after the file was truncated or rotated may duplicate
+them.`,
+}
+
+var importBashCmd = &cobra.Command{
+	Use:   "bash [file]",
+	Short: "Import bash history (default: ~/.bash_history)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImport(cmd, args, "bash", homeFile(".bash_history"), importer.ParseBash)
+	},
+}
+
+var importZshCmd = &cobra.Command{
+	Use:   "zsh [file]",
+	Short: "Import zsh history (default: ~/.zsh_history)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImport(cmd, args, "zsh", homeFile(".zsh_history"), importer.ParseZsh)
+	},
+}
+
+var importFishCmd = &cobra.Command{
+	Use:   "fish [file]",
+	Short: "Import fish history (default: ~/.local/share/fish/fish_history)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImport(cmd, args, "fish", fishHistoryFile, importer.ParseFish)
+	},
+}
+
+var importJSONCmd = &cobra.Command{
+	Use:   "json <file>",
+	Short: "Import a JSON or JSON Lines export (use - for stdin)",
+	Long: `Import a JSON array or JSON Lines export, such as one produced by atuin or
+another history tool.
+
+Each object needs a "command" (or "cmd") and may have "timestamp" (RFC 3339
+or UNIX time), "cwd" (or "directory"), "exit" (or "exit_code", "status"),
+"duration" (nanoseconds or a value like "1.5s") and "hostname".`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImport(cmd, args, "json", nil, importer.ParseJSON)
+	},
+}
+
+var importCSVCmd = &cobra.Command{
+	Use:   "csv <file>",
+	Short: "Import a CSV export with a header row (use - for stdin)",
+	Long: `Import a CSV export with a header row. Columns use the same names as
+"rrk import json"; unknown columns are ignored.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runImport(cmd, args, "csv", nil, importer.ParseCSV)
+	},
+}
+
+// homeFile ホームディレクトリ直下のファイルパスを返す関数を作る
+func homeFile(name string) func() (string, error) {
+	return func() (string, error) {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(homeDir, name), nil
+	}
+}
+
+// fishHistoryFile fishの履歴ファイルのパスを返す（XDG_DATA_HOMEを考慮）
+func fishHistoryFile() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(dataHome, "fish", "fish_history"), nil
+}
+
+// runImport 履歴ファイルを解析し、未取り込みのコマンドを保存
+func runImport(cmd *cobra.Command, args []string, format string, defaultPath func() (string, error), parse func(io.Reader) ([]importer.Record, error)) {
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		var err error
+		path, err = defaultPath()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting home directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	cwd, _ := cmd.Flags().GetString("cwd")
+	if cwd != "" {
+		absCWD, err := filepath.Abs(cwd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving directory: %v\n", err)
+			os.Exit(1)
+		}
+		cwd = absCWD
+	}
+
+	// "-" は標準入力から読む
+	file := os.Stdin
+	if path == "-" {
+		path = "stdin"
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening history file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		file = f
+	}
+
+	records, err := parse(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s history: %v\n", format, err)
+		os.Exit(1)
+	}
+
+	// 時刻のない行はファイルの更新時刻で代用
+	fallbackTime := time.Now()
+	if stat, err := file.Stat(); err == nil && stat.Mode().IsRegular() {
+		fallbackTime = stat.ModTime()
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 取り込み済みのキーを収集
+	imported := importedSessionID
+	existing, err := store.Load(history.EntryFilter{SessionID: &imported})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading history: %v\n", err)
+		os.Exit(1)
+	}
+	knownKeys := make(map[string]bool, len(existing))
+	for _, entry := range existing {
+		if entry.ImportKey != "" {
+			knownKeys[entry.ImportKey] = true
+		}
+	}
+
+	matcher := loadIgnoreMatcher()
+	homeDir, _ := os.UserHomeDir()
+	redactor := loadRedactor()
+	maxCommandBytes := loadConfig().MaxCommandBytes
+
+	var entries []*history.Entry
+	var duplicates, ignored, withCWD int
+	for i, key := range importer.Keys(format, records) {
+		record := records[i]
+		if knownKeys[key] {
+			duplicates++
+			continue
+		}
+		knownKeys[key] = true
+
+		// エクスポートにディレクトリがあればそちらを優先
+		entryCWD := record.CWD
+		if entryCWD == "" {
+			entryCWD = cwd
+		}
+
+		// フックで記録する場合と同じく、先頭のcdを除いた部分で判定する
+		if _, skip := matcher.MatchCommand(record.Command, entryCWD, homeDir); skip {
+			ignored++
+			continue
+		}
+		if record.CWD != "" {
+			withCWD++
+		}
+
+		command, _ := redactor.Redact(record.Command)
+		timestamp := record.Timestamp
+		if timestamp.IsZero() {
+			timestamp = fallbackTime
+		}
+
+		entry := &history.Entry{
+			SessionID: importedSessionID,
+			CWD:       entryCWD,
+			Command:   command,
+			Timestamp: timestamp,
+			ExitCode:  record.ExitCode,
+			Duration:  record.Duration,
+			Hostname:  record.Hostname,
+			ImportKey: key,
+		}
+		entry.TruncateCommand(maxCommandBytes)
+		entries = append(entries, entry)
+	}
+
+	// 保持ポリシーが効くよう、各エントリは実行時刻の月のセグメントに保存する
+	if err := store.Import(entries); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving history: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ Imported %d commands from %s", len(entries), path)
+	fmt.Printf(" (%d already imported, %d ignored)\n", duplicates, ignored)
+	if cwd == "" && len(entries) > withCWD {
+		fmt.Println("Imported commands have no directory, so they are not shown in the tree. Use --cwd to file them under a directory.")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importBashCmd)
+	importCmd.AddCommand(importZshCmd)
+	importCmd.AddCommand(importFishCmd)
+	importCmd.AddCommand(importJSONCmd)
+	importCmd.AddCommand(importCSVCmd)
+	importCmd.PersistentFlags().String("cwd", "", "Directory to file imported commands under (when the source has none)")
+}
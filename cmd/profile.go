@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/MRyutaro/rrk/internal/paths"
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage profiles with separate history",
+	Long: `Profiles keep separate history, for example for "work" and "personal".
+
+The profile is chosen by --profile, then $RRK_PROFILE, then the profile
+selected with "rrk profile use", and otherwise "default". Set RRK_PROFILE
+in a shell to record that shell's commands into another profile:
+
+  export RRK_PROFILE=work
+
+Settings in config.json and the session registry are shared by all profiles.`,
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		current, err := paths.Profile()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		names, err := paths.Profiles()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing profiles: %v\n", err)
+			os.Exit(1)
+		}
+		// まだ履歴のないプロファイルを RRK_PROFILE で指定している場合も表示する
+		found := false
+		for _, name := range names {
+			found = found || name == current
+		}
+		if !found {
+			names = append(names, current)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "\tPROFILE\tDIRECTORY")
+		for _, name := range names {
+			dir, err := paths.ProfileDir(name)
+			if err != nil {
+				continue
+			}
+			marker := ""
+			if name == current {
+				marker = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", marker, name, dir)
+		}
+		w.Flush()
+	},
+}
+
+var profileUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Select the profile used when $RRK_PROFILE is not set",
+	Long: `Select the profile that every shell uses unless $RRK_PROFILE or --profile
+chooses another one. The profile is created if it does not exist yet.
+Use "default" to go back to the default profile.`,
+	Example: `  rrk profile use work
+  rrk profile use default`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		if err := paths.UseProfile(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Error selecting profile: %v\n", err)
+			os.Exit(1)
+		}
+
+		dir, _ := paths.ProfileDir(name)
+		fmt.Printf("✅ Using profile %q (history in %s)\n", name, dir)
+		if env := os.Getenv(paths.ProfileEnv); env != "" && env != name {
+			fmt.Printf("   This shell still uses %q because $%s is set.\n", env, paths.ProfileEnv)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileListCmd)
+	profileCmd.AddCommand(profileUseCmd)
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/session"
+	"github.com/MRyutaro/rrk/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old commands from history",
+	Long: `Delete recorded commands that fall outside a retention policy.
+
+--older-than and --keep-last choose which commands to delete, and --dir and
+--session limit pruning to commands run in a directory or a session. With
+only --dir or --session, every matching command is deleted. Without any
+flags, the "retention" policy in ~/.rrk/config.json is applied.
+
+Changed history files are written to a temporary file and renamed into
+place, so an interrupted prune never loses the rest of the history.`,
+	Example: `  rrk prune --older-than 90d
+  rrk prune --keep-last 10000 --dry-run
+  rrk prune --dir ~/tmp/scratch
+  rrk prune --session work --older-than 7d`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		scope, err := pruneScopeFromFlags(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		policy, err := prunePolicyFromFlags(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// 条件の指定がなければ設定ファイルの保持ポリシーを適用
+		scoped := cmd.Flags().Changed("dir") || cmd.Flags().Changed("session")
+		if policy.IsZero() && !scoped {
+			policy, err = retentionFromConfig(loadConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+				os.Exit(1)
+			}
+			if policy.IsZero() {
+				fmt.Fprintln(os.Stderr, "Error: no retention policy is configured; use --older-than, --keep-last, --dir or --session")
+				os.Exit(1)
+			}
+		}
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		// ドライランでは削除対象を search と同じ形式で一覧表示
+		var visit func(history.Entry)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if dryRun {
+			homeDir, _ := os.UserHomeDir()
+			visit = func(entry history.Entry) {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", entry.ID, entry.Timestamp.Local().Format("2006-01-02 15:04:05"),
+					shortenHome(entry.CWD, homeDir), formatSearchCommand(entry))
+			}
+		}
+
+		count, err := store.Prune(cmd.Context(), scope, policy, dryRun, visit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning history: %v\n", err)
+			os.Exit(1)
+		}
+		w.Flush()
+
+		switch {
+		case count == 0:
+			fmt.Println("No commands to prune.")
+		case dryRun:
+			fmt.Printf("%d commands would be pruned (dry run).\n", count)
+		default:
+			fmt.Printf("✅ Pruned %d commands\n", count)
+		}
+	},
+}
+
+// pruneScopeFromFlags --dir と --session から削除の対象範囲を組み立てる
+func pruneScopeFromFlags(cmd *cobra.Command) (history.EntryFilter, error) {
+	var scope history.EntryFilter
+
+	if dir, _ := cmd.Flags().GetString("dir"); dir != "" {
+		path, err := filepath.Abs(dir)
+		if err != nil {
+			return scope, fmt.Errorf("invalid --dir: %w", err)
+		}
+		scope.CWDPrefix = &path
+	}
+
+	// セッションIDで見つからなければセッション名として扱う
+	if value, _ := cmd.Flags().GetString("session"); value != "" {
+		info, err := session.Lookup(value)
+		if err != nil {
+			return scope, fmt.Errorf("failed to read sessions: %w", err)
+		}
+		if info != nil {
+			scope.SessionID = &value
+			return scope, nil
+		}
+		ids, err := session.FindByName(value)
+		if err != nil {
+			return scope, fmt.Errorf("failed to read sessions: %w", err)
+		}
+		if len(ids) == 0 {
+			return scope, fmt.Errorf("no session with ID or name %q", value)
+		}
+		scope.SessionIDs = ids
+	}
+
+	return scope, nil
+}
+
+// prunePolicyFromFlags --older-than と --keep-last から保持ポリシーを組み立てる
+func prunePolicyFromFlags(cmd *cobra.Command) (storage.Retention, error) {
+	var policy storage.Retention
+
+	if value, _ := cmd.Flags().GetString("older-than"); value != "" {
+		age, err := config.ParseAge(value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid --older-than: %w", err)
+		}
+		if age == 0 {
+			return policy, fmt.Errorf("invalid --older-than: must be greater than zero")
+		}
+		policy.MaxAge = age
+	}
+
+	if cmd.Flags().Changed("keep-last") {
+		keep, _ := cmd.Flags().GetInt("keep-last")
+		if keep <= 0 {
+			return policy, fmt.Errorf("invalid --keep-last: must be greater than zero")
+		}
+		policy.MaxEntries = keep
+	}
+
+	return policy, nil
+}
+
+// retentionFromConfig 設定ファイルの保持ポリシーを返す
+func retentionFromConfig(cfg config.Config) (storage.Retention, error) {
+	maxAge, err := cfg.Retention.MaxAgeDuration()
+	if err != nil {
+		return storage.Retention{}, err
+	}
+	return storage.Retention{MaxAge: maxAge, MaxEntries: cfg.Retention.MaxEntries}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().String("older-than", "", "Delete commands run longer ago than this (e.g. 90d, 12w, 720h)")
+	pruneCmd.Flags().Int("keep-last", 0, "Keep only this many of the newest commands")
+	pruneCmd.Flags().String("dir", "", "Only prune commands run in this directory or below it")
+	pruneCmd.Flags().String("session", "", "Only prune commands from this session (ID or name)")
+	pruneCmd.Flags().Bool("dry-run", false, "List the commands that would be deleted without deleting them")
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/redact"
+	"github.com/spf13/cobra"
+)
+
+var redactCmd = &cobra.Command{
+	Use:   "redact [command]",
+	Short: "Hide secrets in commands before they are stored",
+	Long: `Hide secrets such as API tokens, passwords and key=value credentials.
+
+New commands are redacted automatically when they are recorded. Use --scan
+to clean up history that was recorded before, or pass a command to see how
+it would be stored. Extra patterns can be configured under "redact" in
+~/.rrk/config.json.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		redactor := loadRedactor()
+
+		scan, _ := cmd.Flags().GetBool("scan")
+		if !scan {
+			if len(args) == 0 {
+				cmd.Help()
+				return
+			}
+			redacted, _ := redactor.Redact(strings.Join(args, " "))
+			fmt.Println(redacted)
+			return
+		}
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		// 既存の履歴を走査し、秘密情報を含むエントリを書き換え
+		// ドライランでは読み込むだけで、ロックの取得や監査モードでの拒否もない
+		var redactedIDs []int
+		if dryRun {
+			err = store.Iterate(cmd.Context(), history.EntryFilter{}, func(entry history.Entry) error {
+				if redacted, changed := redactor.Redact(entry.Command); changed {
+					fmt.Printf("%d: %s\n", entry.ID, redacted)
+					redactedIDs = append(redactedIDs, entry.ID)
+				}
+				return nil
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading history: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			err = store.Rewrite(func(entry *history.Entry) bool {
+				if redacted, changed := redactor.Redact(entry.Command); changed {
+					fmt.Printf("%d: %s\n", entry.ID, redacted)
+					redactedIDs = append(redactedIDs, entry.ID)
+					entry.Command = redacted
+				}
+				return true
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error rewriting history: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		switch {
+		case len(redactedIDs) == 0:
+			fmt.Println("No secrets found in history.")
+		case dryRun:
+			fmt.Printf("%d entries would be redacted (dry run).\n", len(redactedIDs))
+		default:
+			fmt.Printf("✅ Redacted %d entries.\n", len(redactedIDs))
+		}
+	},
+}
+
+// loadRedactor 設定ファイルから秘密情報の検出ルールを読み込む
+func loadRedactor() *redact.Redactor {
+	cfg := loadConfig()
+
+	redactor, err := redact.New(cfg.Redact)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading redact patterns: %v\n", err)
+		os.Exit(1)
+	}
+	return redactor
+}
+
+func init() {
+	rootCmd.AddCommand(redactCmd)
+	redactCmd.Flags().Bool("scan", false, "Redact secrets in already recorded history")
+	redactCmd.Flags().Bool("dry-run", false, "With --scan, only list the entries that would change")
+}
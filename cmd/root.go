@@ -2,13 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/MRyutaro/rrk/internal/config"
 	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/paths"
 	"github.com/MRyutaro/rrk/internal/storage"
 	"github.com/MRyutaro/rrk/internal/tree"
 	"github.com/MRyutaro/rrk/internal/updater"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var rootCmd = &cobra.Command{
@@ -22,31 +26,50 @@ were executed in each directory.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// フラグ値を取得
 		maxCommands, _ := cmd.Flags().GetInt("number")
-		
+		slow, _ := cmd.Flags().GetDuration("slow")
+		byBranch, _ := cmd.Flags().GetBool("by-branch")
+
 		// ストレージを初期化
-		store, err := storage.New()
+		store, err := openStorage()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
 			os.Exit(1)
 		}
 
-		// 全履歴を読み込み
-		filter := history.EntryFilter{}
-		entries, err := store.Load(filter)
+		// 絞り込み条件を組み立て
+		filter, err := filterFromFlags(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// 履歴を1件ずつツリーに追加（全件をメモリに読み込まない）
+		builder := tree.NewTreeBuilder()
+		builder.SlowThreshold = slow
+		builder.GroupByBranch = byBranch
+		count := 0
+		err = store.Iterate(cmd.Context(), filter, func(entry history.Entry) error {
+			builder.Add(entry)
+			count++
+			return nil
+		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error loading history: %v\n", err)
 			os.Exit(1)
 		}
 
-		if len(entries) == 0 {
+		if count == 0 && filterFlagsChanged(cmd) {
+			fmt.Println("No matching commands.")
+			return
+		}
+		if count == 0 {
 			fmt.Println("No command history found.")
 			fmt.Println("Run some commands to see them here, or run 'rrk setup' to enable history tracking.")
 			return
 		}
 
 		// ツリーを構築
-		builder := tree.NewTreeBuilder()
-		root := builder.BuildTree(entries, maxCommands)
+		root := builder.Build(maxCommands)
 
 		// 指定されたパスがあるかチェック
 		var targetPath string
@@ -60,10 +83,15 @@ were executed in each directory.`,
 }
 
 func Execute() {
+	// データディレクトリはアップデート確認のキャッシュの場所も決めるため、コマンドの解析より前に適用する
+	args := applyGlobalFlags(os.Args[1:])
+
 	// コマンド実行前にアップデートをチェック
-	if updateMsg := updater.CheckForUpdate(Version); updateMsg != "" {
-		fmt.Fprintln(os.Stderr, updateMsg)
-		fmt.Fprintln(os.Stderr)
+	if !skipUpdateCheck(args) {
+		if updateMsg := updater.CheckForUpdate(Version); updateMsg != "" {
+			fmt.Fprintln(os.Stderr, updateMsg)
+			fmt.Fprintln(os.Stderr)
+		}
 	}
 
 	if err := rootCmd.Execute(); err != nil {
@@ -72,7 +100,82 @@ func Execute() {
 	}
 }
 
+// loadConfig 設定ファイルを読み込み、失敗した場合は終了する
+func loadConfig() config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+// openStorage ストレージを初期化し、設定ファイルの保持ポリシーと監査モードを適用する
+func openStorage() (*storage.Storage, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	return openStorageConfig(cfg)
+}
+
+// openStorageConfig 読み込み済みの設定の保持ポリシー・監査モード・鍵ファイルを適用してストレージを初期化する
+func openStorageConfig(cfg config.Config, opts ...storage.Option) (*storage.Storage, error) {
+	retention, err := retentionFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile, err := cfg.KeyFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	dataDir, err := paths.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]storage.Option{storage.WithRetention(retention), storage.WithAudit(cfg.Audit), storage.WithKeyFile(keyFile)}, opts...)
+	return storage.Open(dataDir, opts...)
+}
+
+// applyGlobalFlags --data-dir と --profile を読み取ってデータディレクトリを設定し、残りの引数を返す
+// サブコマンドのフラグは解析せずに読み飛ばす
+func applyGlobalFlags(args []string) []string {
+	flags := pflag.NewFlagSet("rrk", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.Usage = func() {}
+	flags.SetOutput(io.Discard)
+	dataDir := flags.String("data-dir", "", "")
+	profile := flags.String("profile", "", "")
+	if err := flags.Parse(args); err != nil {
+		return args
+	}
+
+	paths.SetDataDir(*dataDir)
+	paths.SetProfile(*profile)
+	return flags.Args()
+}
+
+// skipUpdateCheck プロンプト毎に実行されるフックや常駐デーモンではアップデート確認を行わない
+func skipUpdateCheck(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "hook", "daemon":
+		return true
+	default:
+		return false
+	}
+}
+
 func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	// 実際の値はExecuteでコマンドの解析より前に適用する（ここではヘルプと検証のために定義）
+	rootCmd.PersistentFlags().String("data-dir", "", "Directory to store history and settings in (default $RRK_HOME, ~/.rrk or $XDG_DATA_HOME/rrk)")
+	rootCmd.PersistentFlags().String("profile", "", "Profile whose history to use (default $RRK_PROFILE or the one selected with 'rrk profile use')")
 	rootCmd.Flags().IntP("number", "n", 0, "Maximum number of commands to show per directory (0 = show all)")
+	rootCmd.Flags().Bool("by-branch", false, "List commands separately per Git branch and show the branch name")
+	addFilterFlags(rootCmd)
 }
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/spf13/cobra"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search [text]",
+	Short: "Search recorded commands",
+	Long: `List recorded commands that contain the given text and match the filter
+flags, one per line with their ID, time and directory.`,
+	Example: `  rrk search docker
+  rrk search --regex '^git (push|pull)' --since 7d
+  rrk search --dir ~/src/app --failed --newest -n 20`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		filter, err := filterFromFlags(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(args) > 0 {
+			filter.CommandContains = args[0]
+		}
+
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		homeDir, _ := os.UserHomeDir()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		count := 0
+		err = store.Iterate(cmd.Context(), filter, func(entry history.Entry) error {
+			count++
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", entry.ID, entry.Timestamp.Local().Format("2006-01-02 15:04:05"),
+				shortenHome(entry.CWD, homeDir), formatSearchCommand(entry))
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error searching history: %v\n", err)
+			os.Exit(1)
+		}
+		w.Flush()
+
+		if count == 0 {
+			fmt.Println("No matching commands.")
+		}
+	},
+}
+
+// formatSearchCommand 1行に収まるようにコマンドを整形し、失敗した場合は終了ステータスを付記
+func formatSearchCommand(entry history.Entry) string {
+	command := strings.ReplaceAll(entry.Command, "\n", "⏎")
+	if entry.Failed() {
+		command = fmt.Sprintf("%s [exit %d]", command, *entry.ExitCode)
+	}
+	return command
+}
+
+// shortenHome ホームディレクトリ以下のパスを ~ で表示
+func shortenHome(path, homeDir string) string {
+	switch {
+	case path == "":
+		return "-"
+	case homeDir == "":
+		return path
+	case path == homeDir:
+		return "~"
+	case strings.HasPrefix(path, homeDir+"/"):
+		return "~" + path[len(homeDir):]
+	default:
+		return path
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+	addFilterFlags(searchCmd)
+	addPagingFlags(searchCmd)
+}
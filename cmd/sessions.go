@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/session"
+	"github.com/spf13/cobra"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List shell sessions that have recorded history",
+	Long: `List the sessions that have commands in the history, together with the
+name, start and end time, host, shell and parent session recorded in
+~/.rrk/sessions/.
+Sessions created before the registry existed show "-" for unknown fields.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		ids, err := store.ListSessions()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing sessions: %v\n", err)
+			os.Exit(1)
+		}
+		if len(ids) == 0 {
+			fmt.Println("No sessions found.")
+			return
+		}
+
+		sessions := make([]session.Info, 0, len(ids))
+		for _, id := range ids {
+			info, err := session.Lookup(id)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			if info == nil {
+				info = &session.Info{ID: id}
+			}
+			sessions = append(sessions, *info)
+		}
+
+		// 開始時刻順（レジストリにないセッションは最後にID順）
+		sort.Slice(sessions, func(i, j int) bool {
+			a, b := sessions[i], sessions[j]
+			if a.StartedAt.IsZero() != b.StartedAt.IsZero() {
+				return !a.StartedAt.IsZero()
+			}
+			if !a.StartedAt.Equal(b.StartedAt) {
+				return a.StartedAt.Before(b.StartedAt)
+			}
+			return a.ID < b.ID
+		})
+
+		current := os.Getenv("RRK_SESSION_ID")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  SESSION\tNAME\tSTARTED\tENDED\tHOST\tSHELL\tPARENT")
+		for _, info := range sessions {
+			marker := " "
+			if info.ID == current {
+				marker = "*"
+			}
+			started := "-"
+			if !info.StartedAt.IsZero() {
+				started = info.StartedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			ended := "-"
+			if info.EndedAt != nil {
+				ended = info.EndedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s %s\t%s\t%s\t%s\t%s\t%s\t%s\n", marker, info.ID, orDash(info.Name),
+				started, ended, orDash(info.Hostname), orDash(info.Shell), orDash(info.Parent))
+		}
+		w.Flush()
+	},
+}
+
+var sessionsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old ended sessions from the session registry",
+	Long: `Remove sessions that ended before --older-than from ~/.rrk/sessions/.
+Only the registry entries are removed; recorded commands stay in the history.
+
+Sessions that have not ended, such as long-lived shells, are never removed.
+A session is also kept while a session that stays in the registry was
+started from it, and named sessions and the sessions started from them are
+kept so that --session-name keeps working.`,
+	Example: `  rrk sessions prune --older-than 90d
+  rrk sessions prune --older-than 30d --dry-run`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		value, _ := cmd.Flags().GetString("older-than")
+		age, err := config.ParseAge(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --older-than: %v\n", err)
+			os.Exit(1)
+		}
+		if age == 0 {
+			fmt.Fprintln(os.Stderr, "Error: invalid --older-than: must be greater than zero")
+			os.Exit(1)
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		removed, err := session.Prune(time.Now().Add(-age), dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error pruning sessions: %v\n", err)
+			os.Exit(1)
+		}
+
+		if dryRun {
+			for _, info := range removed {
+				fmt.Printf("%s\t%s\n", info.ID, info.EndedAt.Local().Format("2006-01-02 15:04:05"))
+			}
+		}
+		switch {
+		case len(removed) == 0:
+			fmt.Println("No sessions to prune.")
+		case dryRun:
+			fmt.Printf("%d sessions would be pruned (dry run).\n", len(removed))
+		default:
+			fmt.Printf("✅ Pruned %d sessions\n", len(removed))
+		}
+	},
+}
+
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Manage the current shell session",
+}
+
+var sessionNameCmd = &cobra.Command{
+	Use:   "name <label>",
+	Short: "Label the current session (filter the tree with --session-name)",
+	Long: `Label the current session, for example "prod hotfix". The tree can then be
+limited to that session and the shells started from it with
+'rrk --session-name "prod hotfix"'. An empty label removes the name.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sessionID, _ := cmd.Flags().GetString("session")
+		if sessionID == "" {
+			sessionID = os.Getenv("RRK_SESSION_ID")
+		}
+		if sessionID == "" || sessionID == "unknown" {
+			fmt.Fprintln(os.Stderr, "Error: no current session. Run 'rrk setup' to enable shell integration, or pass --session.")
+			os.Exit(1)
+		}
+
+		if err := session.SetName(sessionID, args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error naming session: %v\n", err)
+			os.Exit(1)
+		}
+
+		if args[0] == "" {
+			fmt.Printf("✅ Removed the name of session %s\n", sessionID)
+		} else {
+			fmt.Printf("✅ Named session %s %q\n", sessionID, args[0])
+		}
+	},
+}
+
+// orDash 空の値を "-" として表示
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(sessionsPruneCmd)
+	sessionsPruneCmd.Flags().String("older-than", "90d", "Remove sessions that ended longer ago than this (e.g. 30d, 12h)")
+	sessionsPruneCmd.Flags().Bool("dry-run", false, "Only list the sessions that would be removed")
+	rootCmd.AddCommand(sessionCmd)
+	sessionCmd.AddCommand(sessionNameCmd)
+	sessionNameCmd.Flags().String("session", "", "Session to name (defaults to $RRK_SESSION_ID)")
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/MRyutaro/rrk/internal/paths"
 	"github.com/spf13/cobra"
 )
 
@@ -16,8 +17,11 @@ var setupCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("Setting up rrk shell integration...")
 
-		// シェルを検出
-		shell := detectShell()
+		// シェルを検出（--shellフラグが優先）
+		shell, _ := cmd.Flags().GetString("shell")
+		if shell == "" {
+			shell = detectShell()
+		}
 		if shell == "" {
 			fmt.Println("Could not detect shell. Please specify with --shell flag.")
 			os.Exit(1)
@@ -25,10 +29,25 @@ var setupCmd = &cobra.Command{
 
 		fmt.Printf("Detected shell: %s\n", shell)
 
+		// ホームディレクトリを取得
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting home directory: %v\n", err)
+			os.Exit(1)
+		}
+
+		// フックスクリプトとシェル設定ファイルパスを取得
+		script, err := hookScript(shell)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		shellConfigFile := shellConfigPath(homeDir, shell)
+
 		// --yesフラグが使用されていない場合は確認を取る
 		autoConfirm, _ := cmd.Flags().GetBool("yes")
 		if !autoConfirm {
-			fmt.Printf("This will add rrk integration to your ~/.%src file. Continue? [y/N]: ", shell)
+			fmt.Printf("This will add rrk integration to %s. Continue? [y/N]: ", shellConfigFile)
 			var response string
 			if _, err := fmt.Scanln(&response); err != nil {
 				// スキャンエラーを"no"レスポンスとして扱う
@@ -41,66 +60,54 @@ var setupCmd = &cobra.Command{
 			}
 		}
 
-		// フックスクリプトを取得
-		hookScript := ""
-		switch shell {
-		case "bash":
-			hookScript = bashHook()
-		case "zsh":
-			hookScript = zshHook()
-		default:
-			fmt.Printf("Unsupported shell: %s\n", shell)
-			os.Exit(1)
-		}
-
-		// ホームディレクトリを取得
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting home directory: %v\n", err)
-			os.Exit(1)
-		}
-
-		// シェル設定ファイルパス
-		var shellConfigFile string
-		switch shell {
-		case "bash":
-			shellConfigFile = filepath.Join(homeDir, ".bashrc")
-		case "zsh":
-			shellConfigFile = filepath.Join(homeDir, ".zshrc")
-		}
-
 		// rrkが既に設定されているかチェック
 		if isAlreadyConfigured(shellConfigFile) {
 			fmt.Println("rrk integration is already configured!")
 			return
 		}
 
-		// フックスクリプトを設定ディレクトリに書き込み
-		configDir := filepath.Join(homeDir, ".rrk")
-		if err := os.MkdirAll(configDir, 0755); err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating config directory: %v\n", err)
-			os.Exit(1)
-		}
+		// fishはconf.dのスクリプトを自動で読み込むため、フックを直接配置
+		if shell == "fish" {
+			if err := os.MkdirAll(filepath.Dir(shellConfigFile), 0755); err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating fish config directory: %v\n", err)
+				os.Exit(1)
+			}
+			if err := os.WriteFile(shellConfigFile, []byte(script), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing hook file: %v\n", err)
+				os.Exit(1)
+			}
+		} else {
+			// フックスクリプトをデータディレクトリに書き込み
+			configDir, err := paths.RootDir()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error getting data directory: %v\n", err)
+				os.Exit(1)
+			}
+			if err := os.MkdirAll(configDir, 0755); err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating config directory: %v\n", err)
+				os.Exit(1)
+			}
 
-		hookFile := filepath.Join(configDir, "hook.sh")
-		if err := os.WriteFile(hookFile, []byte(hookScript), 0644); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing hook file: %v\n", err)
-			os.Exit(1)
-		}
+			hookFile := filepath.Join(configDir, "hook.sh")
+			if err := os.WriteFile(hookFile, []byte(script), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing hook file: %v\n", err)
+				os.Exit(1)
+			}
 
-		// シェル設定に追加
-		hookLine := fmt.Sprintf("\n# rrk shell integration\nsource %s\n", hookFile)
+			// シェル設定に追加
+			hookLine := fmt.Sprintf("\n# rrk shell integration\nsource %s\n", hookFile)
 
-		file, err := os.OpenFile(shellConfigFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening shell config file: %v\n", err)
-			os.Exit(1)
-		}
-		defer file.Close()
+			file, err := os.OpenFile(shellConfigFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error opening shell config file: %v\n", err)
+				os.Exit(1)
+			}
+			defer file.Close()
 
-		if _, err := file.WriteString(hookLine); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing to shell config file: %v\n", err)
-			os.Exit(1)
+			if _, err := file.WriteString(hookLine); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing to shell config file: %v\n", err)
+				os.Exit(1)
+			}
 		}
 
 		fmt.Println("✅ Setup complete!")
@@ -121,6 +128,27 @@ func detectShell() string {
 		return "bash"
 	case "zsh":
 		return "zsh"
+	case "fish":
+		return "fish"
+	default:
+		return ""
+	}
+}
+
+// shellConfigPath rrk統合を書き込むシェル設定ファイルのパスを返す
+// fishはfish自身と同じく $XDG_CONFIG_HOME/fish（未設定か相対パスなら ~/.config/fish）のconf.dに置く
+func shellConfigPath(homeDir, shell string) string {
+	switch shell {
+	case "bash":
+		return filepath.Join(homeDir, ".bashrc")
+	case "zsh":
+		return filepath.Join(homeDir, ".zshrc")
+	case "fish":
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if !filepath.IsAbs(configHome) {
+			configHome = filepath.Join(homeDir, ".config")
+		}
+		return filepath.Join(configHome, "fish", "conf.d", "rrk.fish")
 	default:
 		return ""
 	}
@@ -129,6 +157,7 @@ func detectShell() string {
 func init() {
 	rootCmd.AddCommand(setupCmd)
 	setupCmd.Flags().BoolP("yes", "y", false, "Automatically confirm setup without prompting")
+	setupCmd.Flags().String("shell", "", "Shell to configure (bash, zsh or fish); detected from $SHELL by default")
 }
 
 // isAlreadyConfigured rrk統合が既に設定されているかチェック
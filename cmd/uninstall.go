@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/MRyutaro/rrk/internal/paths"
 	"github.com/spf13/cobra"
 )
 
@@ -34,42 +35,52 @@ var uninstallCmd = &cobra.Command{
 			}
 		}
 
-		// Detect shell
-		shell := detectShell()
-		if shell == "" {
-			fmt.Println("Could not detect shell. Manual cleanup may be required.")
+		// Remove shell integration from every supported shell that has it
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting home directory: %v\n", err)
 		} else {
-			// Remove shell integration
-			if err := removeShellIntegration(shell); err != nil {
-				fmt.Fprintf(os.Stderr, "Error removing shell integration: %v\n", err)
-			} else {
-				fmt.Printf("✅ Removed shell integration from ~/.%src\n", shell)
+			for _, shell := range []string{"bash", "zsh", "fish"} {
+				configFile := shellConfigPath(homeDir, shell)
+				if !isAlreadyConfigured(configFile) {
+					continue
+				}
+				if err := removeShellIntegration(shell); err != nil {
+					fmt.Fprintf(os.Stderr, "Error removing shell integration: %v\n", err)
+				} else {
+					fmt.Printf("✅ Removed shell integration from %s\n", configFile)
+				}
 			}
 		}
 
-		// Remove hook file
-		homeDir, err := os.UserHomeDir()
+		// Remove hook file and all rrk data (every profile)
+		rrkDir, err := paths.RootDir()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error getting home directory: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error getting data directory: %v\n", err)
 		} else {
-			hookFile := filepath.Join(homeDir, ".rrk", "hook.sh")
+			hookFile := filepath.Join(rrkDir, "hook.sh")
 			if err := os.Remove(hookFile); err != nil && !os.IsNotExist(err) {
 				fmt.Fprintf(os.Stderr, "Error removing hook file: %v\n", err)
 			} else {
 				fmt.Println("✅ Removed hook script")
 			}
-		}
 
-		// Remove all rrk data
-		if homeDir != "" {
-			rrkDir := filepath.Join(homeDir, ".rrk")
-			if err := os.RemoveAll(rrkDir); err != nil {
+			if err := removeRRKData(rrkDir); err != nil {
 				fmt.Fprintf(os.Stderr, "Error removing data directory: %v\n", err)
 			} else {
 				fmt.Println("✅ Removed all rrk data")
 			}
 		}
 
+		// Remove the config directory when it is separate from the data directory ($XDG_CONFIG_HOME/rrk)
+		if configDir, err := paths.ConfigDir(); err == nil && configDir != rrkDir {
+			if err := removeRRKData(configDir); err != nil {
+				fmt.Fprintf(os.Stderr, "Error removing config directory: %v\n", err)
+			} else {
+				fmt.Println("✅ Removed rrk config")
+			}
+		}
+
 		// Instructions for removing binary
 		fmt.Println("\n📦 To complete uninstallation, remove the rrk binary:")
 		fmt.Println("  sudo rm /usr/local/bin/rrk")
@@ -78,21 +89,95 @@ var uninstallCmd = &cobra.Command{
 
 		fmt.Println("\n✨ Uninstall completed!")
 		fmt.Println("Please restart your shell or run 'source ~/.zshrc' (or ~/.bashrc)")
+		fmt.Println("fish users only need to start a new shell.")
 	},
 }
 
+// rrkFiles rrkがデータディレクトリ（と各プロファイルのディレクトリ）に作成するファイル
+var rrkFiles = []string{
+	"history.jsonl", "next_id", "lock", "key", "quarantine.jsonl",
+	"hook.sh", "config.json", "profile", "daemon.sock", ".rrk_version_cache",
+}
+
+// removeRRKData rrkが作成したファイルだけを削除し、空になったディレクトリを削除する
+// --data-dir や RRK_HOME に既存のディレクトリを指定していても、ユーザーのファイルは消さない
+func removeRRKData(dir string) error {
+	if err := removeRRKFiles(dir); err != nil {
+		return err
+	}
+
+	profilesDir := filepath.Join(dir, "profiles")
+	entries, err := os.ReadDir(profilesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && paths.ValidateProfile(entry.Name()) == nil {
+			if err := removeRRKFiles(filepath.Join(profilesDir, entry.Name())); err != nil {
+				return err
+			}
+			removeIfEmpty(filepath.Join(profilesDir, entry.Name()))
+		}
+	}
+	removeIfEmpty(profilesDir)
+	removeIfEmpty(dir)
+	return nil
+}
+
+// removeRRKFiles ディレクトリ内のrrkが作成したファイルを削除する
+// history と sessions は、rrkのマニフェストやセッションファイルだけを含む場合に限り削除する
+func removeRRKFiles(dir string) error {
+	for _, name := range rrkFiles {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	historyDir := filepath.Join(dir, "history")
+	if _, err := os.Stat(filepath.Join(historyDir, "manifest.json")); err == nil {
+		if err := os.RemoveAll(historyDir); err != nil {
+			return err
+		}
+	}
+
+	sessionsDir := filepath.Join(dir, "sessions")
+	entries, err := os.ReadDir(sessionsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && (strings.HasSuffix(name, ".json") || name == "lock") {
+			if err := os.Remove(filepath.Join(sessionsDir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	removeIfEmpty(sessionsDir)
+	return nil
+}
+
+// removeIfEmpty ディレクトリが空であれば削除する（他のファイルが残っていれば何もしない）
+func removeIfEmpty(dir string) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		os.Remove(dir)
+	}
+}
+
 func removeShellIntegration(shell string) error {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return err
 	}
 
-	var configFile string
+	configFile := shellConfigPath(homeDir, shell)
 	switch shell {
-	case "bash":
-		configFile = filepath.Join(homeDir, ".bashrc")
-	case "zsh":
-		configFile = filepath.Join(homeDir, ".zshrc")
+	case "bash", "zsh":
+	case "fish":
+		// fishはconf.d内のrrk専用ファイルなので丸ごと削除
+		if err := os.Remove(configFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
 	default:
 		return fmt.Errorf("unsupported shell: %s", shell)
 	}
@@ -106,15 +191,18 @@ func removeShellIntegration(shell string) error {
 	// rrk統合行を削除
 	lines := strings.Split(string(content), "\n")
 	var newLines []string
-	skipNext := false
+	skipNext, afterMarker := false, false
 
 	for i, line := range lines {
 		trimmed := strings.TrimSpace(line)
 
-		// rrk関連行をスキップ
-		if strings.Contains(trimmed, "rrk shell integration") ||
+		// rrk関連行をスキップ（--data-dir で設定した場合、source行のパスにrrkが含まれないためマーカーの次の行も見る）
+		isSource := strings.HasPrefix(trimmed, "source") && strings.HasSuffix(trimmed, "hook.sh")
+		marker := strings.Contains(trimmed, "rrk shell integration")
+		if marker ||
 			strings.Contains(trimmed, "rrk hook init") ||
-			(strings.HasPrefix(trimmed, "source") && strings.Contains(trimmed, "rrk/hook.sh")) {
+			(isSource && (afterMarker || strings.Contains(trimmed, "rrk/hook.sh"))) {
+			afterMarker = marker
 			// コメントだけの場合は次の行もスキップ
 			if i+1 < len(lines) && strings.TrimSpace(lines[i+1]) == "" {
 				skipNext = true
@@ -122,6 +210,7 @@ func removeShellIntegration(shell string) error {
 			continue
 		}
 
+		afterMarker = false
 		if skipNext {
 			skipNext = false
 			continue
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify that audited history was not modified",
+	Long: `Walk the hash chain of commands recorded in audit mode and report the
+first broken link.
+
+In audit mode ("audit": true in ~/.rrk/config.json), each recorded command
+carries the hash of the command before it, and rrk refuses to delete or edit
+recorded history. Editing, deleting or inserting a line in the history files
+afterwards breaks the chain, and so does deleting the newest commands.
+
+The hashes are not keyed and the chain's end is stored in the history
+directory, so someone who can rewrite the history can also recompute the
+whole chain. To detect that, keep the head hash printed by a successful
+run somewhere else and pass it later with --head: the check then fails if
+that command is no longer part of the chain.`,
+	Example: `  rrk verify
+  rrk verify --head 3b1f...e9`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := openStorage()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error initializing storage: %v\n", err)
+			os.Exit(1)
+		}
+
+		head, _ := cmd.Flags().GetString("head")
+		result, err := store.Verify(cmd.Context(), head)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error verifying history: %v\n", err)
+			os.Exit(1)
+		}
+
+		if broken := result.Broken; broken != nil {
+			location := "at the end of the history"
+			if broken.Segment != "" {
+				location = fmt.Sprintf("at %s:%d", broken.Segment, broken.Line)
+				if broken.ID != 0 {
+					location += fmt.Sprintf(" (ID %d)", broken.ID)
+				}
+			}
+			fmt.Printf("❌ Hash chain is broken %s: %s\n", location, broken.Reason)
+			fmt.Printf("   %d commands were verified before the break.\n", result.Chained)
+			os.Exit(1)
+		}
+
+		if result.Chained == 0 {
+			fmt.Println("No commands have been recorded in audit mode yet.")
+			fmt.Println("Enable it with \"audit\": true in ~/.rrk/config.json.")
+			return
+		}
+		fmt.Printf("✅ Hash chain is intact (%d of %d commands were recorded in audit mode)\n", result.Chained, result.Entries)
+		if head != "" {
+			fmt.Println("✅ The given head is still part of the chain")
+		}
+		fmt.Printf("   Head: %s (ID %d)\n", result.Head, result.HeadID)
+		fmt.Println("   Keep this hash outside this machine and check later with \"rrk verify --head <hash>\".")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().String("head", "", "Head hash from an earlier run that must still be part of the chain")
+}
@@ -0,0 +1,39 @@
+// failed 最近失敗したコマンドを新しい順に表示する
+//
+//	go run ./examples/failed -n 20
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/MRyutaro/rrk/pkg/rrk"
+)
+
+func main() {
+	limit := flag.Int("n", 10, "Number of commands to show")
+	dir := flag.String("dir", "", "Only show commands run in this directory or below")
+	flag.Parse()
+
+	store, err := rrk.OpenDefault()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries, err := store.Entries(context.Background(), rrk.Filter{
+		DirPrefix: *dir,
+		Failed:    true,
+		Newest:    true,
+		Limit:     *limit,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("%s  exit %-3d  %s  %s\n",
+			entry.Timestamp.Format("2006-01-02 15:04:05"), *entry.ExitCode, entry.Dir, entry.Command)
+	}
+}
@@ -0,0 +1,72 @@
+// top よく使うコマンドと、失敗した割合を集計する
+//
+//	go run ./examples/top -n 10
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/MRyutaro/rrk/pkg/rrk"
+)
+
+// stats コマンド名ごとの集計
+type stats struct {
+	name   string
+	runs   int
+	failed int
+}
+
+func main() {
+	limit := flag.Int("n", 10, "Number of commands to show")
+	flag.Parse()
+
+	store, err := rrk.OpenDefault()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Eachは1件ずつ読み込むため、履歴が大きくても全件をメモリに載せずに集計できる
+	counts := make(map[string]*stats)
+	err = store.Each(context.Background(), rrk.Filter{}, func(entry rrk.Entry) error {
+		fields := strings.Fields(entry.Command)
+		if len(fields) == 0 {
+			return nil
+		}
+		s := counts[fields[0]]
+		if s == nil {
+			s = &stats{name: fields[0]}
+			counts[fields[0]] = s
+		}
+		s.runs++
+		if entry.Failed() {
+			s.failed++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ranking := make([]*stats, 0, len(counts))
+	for _, s := range counts {
+		ranking = append(ranking, s)
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].runs != ranking[j].runs {
+			return ranking[i].runs > ranking[j].runs
+		}
+		return ranking[i].name < ranking[j].name
+	})
+
+	for i, s := range ranking {
+		if i == *limit {
+			break
+		}
+		fmt.Printf("%6d  %5.1f%% failed  %s\n", s.runs, 100*float64(s.failed)/float64(s.runs), s.name)
+	}
+}
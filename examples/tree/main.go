@@ -0,0 +1,45 @@
+// tree 指定した期間のコマンドをディレクトリごとのツリーで表示する
+//
+//	go run ./examples/tree -since 168h -n 3
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MRyutaro/rrk/pkg/rrk"
+)
+
+func main() {
+	since := flag.Duration("since", 24*time.Hour, "Only include commands run within this duration")
+	limit := flag.Int("n", 5, "Number of commands to show per directory")
+	flag.Parse()
+
+	store, err := rrk.OpenDefault()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	root, err := store.Tree(context.Background(),
+		rrk.Filter{Since: time.Now().Add(-*since)},
+		rrk.TreeOptions{Limit: *limit, SlowThreshold: 10 * time.Second})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	root.Walk(func(node *rrk.TreeNode, depth int) {
+		// ルート（depth 0）は名前のないノードなので、その子から字下げする
+		indent := strings.Repeat("  ", max(depth-1, 0))
+		if node.Name != "" {
+			fmt.Printf("%s%s/\n", indent, node.Name)
+			indent += "  "
+		}
+		for _, command := range node.Commands {
+			fmt.Printf("%s- %s\n", indent, command)
+		}
+	})
+}
@@ -80,6 +80,9 @@ case "$SHELL_NAME" in
     bash|zsh)
         SHELL_CONFIG_FILE="$HOME/.${SHELL_NAME}rc"
         ;;
+    fish)
+        SHELL_CONFIG_FILE="$HOME/.config/fish/config.fish"
+        ;;
     *)
         SHELL_NAME="unknown"
         ;;
@@ -87,7 +90,12 @@ esac
 
 # Setup PATH if needed
 if [ "$PATH_SETUP_NEEDED" = true ] && [ "$SHELL_NAME" != "unknown" ]; then
-    echo "export PATH=\"\$PATH:${INSTALL_DIR}\"" >> "$SHELL_CONFIG_FILE"
+    if [ "$SHELL_NAME" = "fish" ]; then
+        mkdir -p "$(dirname "$SHELL_CONFIG_FILE")"
+        echo "set -gx PATH \$PATH ${INSTALL_DIR}" >> "$SHELL_CONFIG_FILE"
+    else
+        echo "export PATH=\"\$PATH:${INSTALL_DIR}\"" >> "$SHELL_CONFIG_FILE"
+    fi
     echo "✅ Added ${INSTALL_DIR} to PATH in $SHELL_CONFIG_FILE"
     export PATH="$PATH:${INSTALL_DIR}"
 fi
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/paths"
+)
+
+// Config 設定ファイル（config.json）で設定可能な項目
+type Config struct {
+	Ignore IgnoreConfig `json:"ignore"`
+	Redact RedactConfig `json:"redact"`
+	// MaxCommandBytes 記録するコマンドの最大バイト数（超えた分は切り詰める。0で無制限）
+	MaxCommandBytes int `json:"max_command_bytes"`
+	// Retention 履歴を保持する範囲（月が変わりセグメントを圧縮する際に自動で適用）
+	Retention RetentionConfig `json:"retention"`
+	// Audit 監査モード（各エントリに直前のエントリのハッシュを含め、履歴の書き換えを禁止する）
+	Audit bool `json:"audit"`
+	// KeyFile rrk encrypt で作成する鍵ファイルのパス（空ならデータディレクトリの key、"~/" はホームディレクトリ）
+	KeyFile string `json:"key_file"`
+}
+
+// KeyFilePath KeyFileの "~/" を展開した絶対パスを返す（未設定なら空文字列）
+func (c Config) KeyFilePath() (string, error) {
+	path := c.KeyFile
+	if path == "" {
+		return "", nil
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		path = filepath.Join(homeDir, rest)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid key_file %q: %w", c.KeyFile, err)
+	}
+	return abs, nil
+}
+
+// IgnoreConfig 履歴に記録しないコマンドのルール
+type IgnoreConfig struct {
+	// Words 先頭の単語が完全一致するコマンドを除外
+	Words []string `json:"words"`
+	// Globs コマンド全体にマッチするglobパターン（*は任意の文字列）
+	Globs []string `json:"globs"`
+	// Regexes コマンドの一部にマッチする正規表現
+	Regexes []string `json:"regexes"`
+	// IgnoreSpace 先頭が空白のコマンドを除外（HISTCONTROL=ignorespace相当）
+	IgnoreSpace bool `json:"ignore_space"`
+}
+
+// RedactConfig 保存前にコマンドから秘密情報を伏せるルール
+type RedactConfig struct {
+	// Patterns 追加で伏せる正規表現（名前付きグループ"secret"があればその部分のみ置換）
+	Patterns []string `json:"patterns"`
+	// DisableBuiltin 組み込みの検出ルールを無効化
+	DisableBuiltin bool `json:"disable_builtin"`
+	// Placeholder 置換後の文字列
+	Placeholder string `json:"placeholder"`
+}
+
+// RetentionConfig 古い履歴を自動で削除するルール（未設定なら無期限に保持）
+type RetentionConfig struct {
+	// MaxAge これより前に実行されたコマンドを削除（例: "90d", "12w", "720h"）
+	MaxAge string `json:"max_age"`
+	// MaxEntries 新しい順にこの件数を超えたコマンドを削除
+	MaxEntries int `json:"max_entries"`
+}
+
+// MaxAgeDuration MaxAgeを期間として解釈する（未設定なら0）
+func (r RetentionConfig) MaxAgeDuration() (time.Duration, error) {
+	if r.MaxAge == "" {
+		return 0, nil
+	}
+	d, err := ParseAge(r.MaxAge)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention.max_age: %w", err)
+	}
+	return d, nil
+}
+
+// ParseAge time.ParseDurationに日（d）と週（w）の単位を加えて解釈
+func ParseAge(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	} {
+		if number, ok := strings.CutSuffix(value, suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// Default 設定ファイルがない場合のデフォルト設定を返す
+func Default() Config {
+	return Config{
+		Ignore: IgnoreConfig{
+			Words:       []string{"cd", "pushd", "popd", "clear"},
+			IgnoreSpace: true,
+		},
+		Redact: RedactConfig{
+			Placeholder: "[REDACTED]",
+		},
+		MaxCommandBytes: 64 * 1024,
+	}
+}
+
+// Path 設定ファイルのパスを返す（全てのプロファイルで共有する）
+func Path() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+// Load 設定ファイルを読み込み（ファイルに書かれていない項目はデフォルト値のまま）
+func Load() (Config, error) {
+	cfg := Default()
+
+	path, err := Path()
+	if err != nil {
+		return cfg, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
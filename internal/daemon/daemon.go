@@ -0,0 +1,358 @@
+package daemon
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/paths"
+	"github.com/MRyutaro/rrk/internal/recorder"
+	"github.com/MRyutaro/rrk/internal/session"
+	"github.com/MRyutaro/rrk/internal/storage"
+)
+
+// 行ベースのプロトコルで使うコマンドと応答（除外ルールに一致したコマンドのIDは0）
+//
+//	RECORD <request json>  ->  OK <id> | ERR <message>
+//	PING                 ->  PONG
+//	STOP                 ->  OK
+const (
+	cmdRecord = "RECORD"
+	cmdPing   = "PING"
+	cmdStop   = "STOP"
+
+	dialTimeout = 200 * time.Millisecond
+	ioTimeout   = 2 * time.Second
+)
+
+// ErrAlreadyRunning 既に別のデーモンがソケットで待ち受けている
+var ErrAlreadyRunning = errors.New("rrk daemon is already running")
+
+// SocketPath デーモンのUnixソケットのパスを返す（デーモンはプロファイルごとに起動する）
+func SocketPath() (string, error) {
+	dir, err := paths.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+// Backend 設定ファイルから作成した、記録に使うStorageとRecorderの設定
+type Backend struct {
+	Store *storage.Storage
+	// Recorder 除外ルール・伏せ字化・切り詰めの設定
+	Recorder []recorder.Option
+}
+
+// Server Storageを所有し、ソケット経由で記録要求を受け付ける
+type Server struct {
+	listener net.Listener
+	path     string
+	done     chan struct{}
+	once     sync.Once
+
+	// open 設定を読み込んでBackendを作成する関数、configPath 変更を監視する設定ファイル
+	open       func() (*Backend, error)
+	configPath string
+
+	// mu backendと、backendを作成した時点の設定ファイルの状態を保護する
+	mu          sync.Mutex
+	backend     *Backend
+	configStamp string
+}
+
+// Listen ソケットを作成して待ち受けを開始
+// 除外ルールや監査モード、保持ポリシーの変更が再起動なしで反映されるよう、configPathが変わるたびにopenでBackendを作り直す
+// Storageは保存に使う状態をメモリに保持するよう、storage.WithWriteCacheを付けて開くことを想定している
+func Listen(path, configPath string, open func() (*Backend, error)) (*Server, error) {
+	// 応答するデーモンがいなければ古いソケットファイルを掃除
+	if _, err := os.Stat(path); err == nil {
+		if Ping(path) == nil {
+			return nil, ErrAlreadyRunning
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	server := &Server{
+		listener:   listener,
+		path:       path,
+		done:       make(chan struct{}),
+		open:       open,
+		configPath: configPath,
+	}
+	// 設定やStorageの問題は起動時に報告する
+	if _, err := server.load(); err != nil {
+		listener.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return server, nil
+}
+
+// load 設定ファイルが前回読み込んだ時から変わっていればBackendを作り直して返す
+func (s *Server) load() (*Backend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stamp := fileStamp(s.configPath)
+	if s.backend != nil && stamp == s.configStamp {
+		return s.backend, nil
+	}
+	backend, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	s.backend, s.configStamp = backend, stamp
+	return backend, nil
+}
+
+// fileStamp 変更の検出に使うファイルの更新時刻とサイズ（なければ空文字列）
+func fileStamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// Serve Closeされるまで接続を処理
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return fmt.Errorf("failed to accept connection: %w", err)
+			}
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close 待ち受けを停止してソケットファイルを削除
+func (s *Server) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		err = s.listener.Close()
+		_ = os.Remove(s.path)
+	})
+	return err
+}
+
+// handle 1接続分のリクエスト行を処理
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		verb, payload, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		var reply string
+		switch verb {
+		case cmdRecord:
+			reply = s.record(payload)
+		case cmdPing:
+			reply = "PONG"
+		case cmdStop:
+			fmt.Fprintln(conn, "OK")
+			s.Close()
+			return
+		default:
+			reply = fmt.Sprintf("ERR unknown command %q", verb)
+		}
+
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// record 受信したコマンドを履歴エントリに変換して保存し、応答行を返す
+func (s *Server) record(payload string) string {
+	var req Request
+	if err := json.Unmarshal([]byte(payload), &req); err != nil {
+		return fmt.Sprintf("ERR invalid request: %v", err)
+	}
+	backend, err := s.load()
+	if err != nil {
+		return fmt.Sprintf("ERR %v", err)
+	}
+
+	rec := recorder.New(append(backend.Recorder, req.Options()...)...)
+	entry, err := rec.Entry(req.Command)
+	if err != nil {
+		return fmt.Sprintf("ERR %v", err)
+	}
+	if entry == nil {
+		return "OK 0"
+	}
+	// IDはデーモン側でのみ割り当てる（同じRecordIDを保存済みならそのIDを返す）
+	entry.RecordID = req.RecordID
+	if err := backend.Store.Save(entry); err != nil {
+		return fmt.Sprintf("ERR %v", err)
+	}
+	return fmt.Sprintf("OK %d", entry.ID)
+}
+
+// Request フックからデーモンに送る記録要求
+// 除外ルールなどの設定はデーモンが適用するため、フックは設定を読まずにシェルから渡された情報と自身の環境だけを送る
+type Request struct {
+	Command recorder.Command `json:"command"`
+	// SessionID, Metadata, HomeDir, Time フックのプロセスの環境から取得した値
+	SessionID string           `json:"session_id"`
+	Metadata  session.Metadata `json:"metadata"`
+	HomeDir   string           `json:"home_dir"`
+	Time      time.Time        `json:"time"`
+	// RecordID 応答が届かなかった場合に直接書き込んでも重複しないよう、要求ごとに付ける識別子
+	RecordID string `json:"record_id"`
+}
+
+// NewRequest 現在のプロセスの環境からcの記録要求を作成
+// 作業ディレクトリが空ならここで補う（デーモンの作業ディレクトリはフックと異なるため）
+func NewRequest(c recorder.Command) (*Request, error) {
+	if c.CWD == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current directory: %w", err)
+		}
+		c.CWD = cwd
+	}
+	sessionID, err := session.GetCurrentSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session ID: %w", err)
+	}
+	recordID, err := newRecordID()
+	if err != nil {
+		return nil, err
+	}
+	homeDir, _ := os.UserHomeDir()
+
+	return &Request{
+		Command:   c,
+		SessionID: sessionID,
+		Metadata:  session.CurrentMetadata(),
+		HomeDir:   homeDir,
+		Time:      time.Now(),
+		RecordID:  recordID,
+	}, nil
+}
+
+// Options フックの環境の代わりに要求の値を使うRecorderの設定を返す
+// デーモンとフックの直接書き込みで同じエントリになるよう、どちらもこの設定でRecorderを作る
+func (req *Request) Options() []recorder.Option {
+	return []recorder.Option{
+		recorder.WithClock(func() time.Time { return req.Time }),
+		recorder.WithSession(func() (string, error) { return req.SessionID, nil }),
+		recorder.WithMetadata(func() session.Metadata { return req.Metadata }),
+		recorder.WithHomeDir(func() (string, error) { return req.HomeDir, nil }),
+	}
+}
+
+// Record 記録要求をデーモンに送信し、保存されたエントリのIDを返す（除外ルールに一致した場合は0）
+func Record(path string, req *Request) (int, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	reply, err := request(path, cmdRecord+" "+string(data))
+	if err != nil {
+		return 0, err
+	}
+
+	idText, ok := strings.CutPrefix(reply, "OK ")
+	if !ok {
+		return 0, fmt.Errorf("daemon rejected entry: %s", strings.TrimPrefix(reply, "ERR "))
+	}
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		return 0, fmt.Errorf("invalid daemon reply: %q", reply)
+	}
+	return id, nil
+}
+
+// newRecordID 記録要求ごとのランダムな識別子を作成
+func newRecordID() (string, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate record ID: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// Ping デーモンが応答するか確認
+func Ping(path string) error {
+	reply, err := request(path, cmdPing)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("invalid daemon reply: %q", reply)
+	}
+	return nil
+}
+
+// Stop デーモンに停止を要求
+func Stop(path string) error {
+	reply, err := request(path, cmdStop)
+	if err != nil {
+		return err
+	}
+	if reply != "OK" {
+		return fmt.Errorf("invalid daemon reply: %q", reply)
+	}
+	return nil
+}
+
+// request 1行のリクエストを送り、1行の応答を受け取る
+func request(path, line string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to daemon: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(ioTimeout)); err != nil {
+		return "", fmt.Errorf("failed to set deadline: %w", err)
+	}
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read reply: %w", err)
+	}
+	return strings.TrimRight(reply, "\r\n"), nil
+}
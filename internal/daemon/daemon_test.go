@@ -0,0 +1,202 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/ignore"
+	"github.com/MRyutaro/rrk/internal/recorder"
+	"github.com/MRyutaro/rrk/internal/session"
+	"github.com/MRyutaro/rrk/internal/storage"
+)
+
+// startServer 一時ディレクトリでデーモンを起動し、テスト終了時に停止する
+// openはBackendを作り直すたびに呼ばれる
+func startServer(t *testing.T, configPath string, open func() (*Backend, error)) string {
+	t.Helper()
+	// Unixソケットのパスは長さに制限があるため短いディレクトリに作る
+	dir, err := os.MkdirTemp("", "rrkd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "daemon.sock")
+	server, err := Listen(socketPath, configPath, open)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+	return socketPath
+}
+
+// testRequest 実行環境に依存しない記録要求を作成
+func testRequest(command, recordID string) *Request {
+	return &Request{
+		Command:   recorder.Command{Text: command, CWD: "/tmp"},
+		SessionID: "s",
+		Metadata:  session.Metadata{Hostname: "host"},
+		HomeDir:   "/home/dev",
+		Time:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		RecordID:  recordID,
+	}
+}
+
+// TestRecordSkipsResentEntry 応答が届かずに同じ記録要求を再送したり、フックが直接書き込んだりしても
+// 1件しか保存されないことを確かめる
+func TestRecordSkipsResentEntry(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := storage.Open(dataDir, storage.WithWriteCache())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	socketPath := startServer(t, filepath.Join(dataDir, "config.json"), func() (*Backend, error) {
+		return &Backend{Store: store}, nil
+	})
+
+	req := testRequest("make", "r1")
+	id, err := Record(socketPath, req)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if id == 0 {
+		t.Fatal("Record returned ID 0")
+	}
+
+	// 再送
+	resent, err := Record(socketPath, req)
+	if err != nil {
+		t.Fatalf("Record again: %v", err)
+	}
+	// デーモンの応答を待たずにフックが直接書き込んだ場合（フックはデーモンとは別にStorageを開く）
+	entry, err := recorder.New(req.Options()...).Entry(req.Command)
+	if err != nil {
+		t.Fatalf("Entry: %v", err)
+	}
+	entry.RecordID = req.RecordID
+	direct, err := storage.Open(dataDir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := direct.Save(entry); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if resent != id || entry.ID != id {
+		t.Errorf("IDs = %d, %d, want the first ID %d", resent, entry.ID, id)
+	}
+	entries, err := direct.Load(history.EntryFilter{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("stored %d entries, want 1", len(entries))
+	}
+	if got := entries[0]; got.Command != "make" || got.SessionID != "s" || got.Hostname != "host" || !got.Timestamp.Equal(req.Time) {
+		t.Errorf("stored %+v, want the values from the request", got)
+	}
+}
+
+// TestRecordSeesDirectWrites デーモンが動いている間にフックが直接書き込んでも、IDが重複しないことを確かめる
+func TestRecordSeesDirectWrites(t *testing.T) {
+	dataDir := t.TempDir()
+	socketPath := startServer(t, filepath.Join(dataDir, "config.json"), func() (*Backend, error) {
+		store, err := storage.Open(dataDir, storage.WithWriteCache())
+		return &Backend{Store: store}, err
+	})
+	direct, err := storage.Open(dataDir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	ids := make(map[int]bool)
+	for i := 0; i < 4; i++ {
+		id, err := Record(socketPath, testRequest("daemon", ""))
+		if err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		entry := &history.Entry{SessionID: "s", CWD: "/tmp", Command: "direct", Timestamp: time.Now()}
+		if err := direct.Save(entry); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		for _, id := range []int{id, entry.ID} {
+			if ids[id] {
+				t.Fatalf("ID %d was assigned twice", id)
+			}
+			ids[id] = true
+		}
+	}
+}
+
+// TestRecordAppliesIgnoreRules 除外ルールはデーモンが適用し、一致したコマンドは保存しないことを確かめる
+func TestRecordAppliesIgnoreRules(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := storage.Open(dataDir, storage.WithWriteCache())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	matcher, err := ignore.New(config.IgnoreConfig{Words: []string{"ls"}})
+	if err != nil {
+		t.Fatalf("ignore.New: %v", err)
+	}
+	socketPath := startServer(t, filepath.Join(dataDir, "config.json"), func() (*Backend, error) {
+		return &Backend{Store: store, Recorder: []recorder.Option{recorder.WithIgnore(matcher)}}, nil
+	})
+
+	if id, err := Record(socketPath, testRequest("ls", "")); err != nil || id != 0 {
+		t.Fatalf("Record(ls) = %d, %v, want 0 for an ignored command", id, err)
+	}
+	if id, err := Record(socketPath, testRequest("make", "")); err != nil || id == 0 {
+		t.Fatalf("Record(make) = %d, %v", id, err)
+	}
+	entries, err := store.Load(history.EntryFilter{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Command != "make" {
+		t.Errorf("stored %+v, want only make", entries)
+	}
+}
+
+// TestStorageReopensWhenConfigChanges 設定ファイルが変わると、次の記録でBackendを作り直すことを確かめる
+func TestStorageReopensWhenConfigChanges(t *testing.T) {
+	dataDir := t.TempDir()
+	configPath := filepath.Join(dataDir, "config.json")
+	if err := os.WriteFile(configPath, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var opened atomic.Int32
+	socketPath := startServer(t, configPath, func() (*Backend, error) {
+		opened.Add(1)
+		store, err := storage.Open(dataDir, storage.WithWriteCache())
+		return &Backend{Store: store}, err
+	})
+
+	record := func() {
+		t.Helper()
+		if _, err := Record(socketPath, testRequest("ls", "")); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	record()
+	record()
+	if n := opened.Load(); n != 1 {
+		t.Fatalf("opened storage %d times without a config change, want 1", n)
+	}
+
+	if err := os.WriteFile(configPath, []byte(`{"audit": true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	record()
+	if n := opened.Load(); n != 2 {
+		t.Errorf("opened storage %d times after the config changed, want 2", n)
+	}
+}
@@ -0,0 +1,23 @@
+package filelock
+
+import (
+	"fmt"
+	"os"
+)
+
+// Lock pathのファイルに排他ロックをかけ（他プロセスが解放するまで待つ）、解放する関数を返す
+// ロック用のファイルがなければ作成する
+func Lock(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,124 @@
+package gitinfo
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Info コマンド実行時のGitリポジトリの状態
+type Info struct {
+	Root   string
+	Branch string
+	Commit string
+}
+
+// Detect dirを含むGitリポジトリの情報を返す（リポジトリ外ならnil）
+// gitコマンドは起動せず、.git/HEADとrefsを直接読む
+func Detect(dir string) *Info {
+	root, gitDir := findGitDir(dir)
+	if gitDir == "" {
+		return nil
+	}
+
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return nil
+	}
+
+	info := &Info{Root: root}
+	headText := strings.TrimSpace(string(head))
+	ref, isRef := strings.CutPrefix(headText, "ref: ")
+	if !isRef {
+		// detached HEAD
+		info.Commit = headText
+		return info
+	}
+
+	info.Branch = strings.TrimPrefix(ref, "refs/heads/")
+	info.Commit = resolveRef(gitDir, ref)
+	return info
+}
+
+// findGitDir dirから親に向かって.gitを探し、作業ツリーのルートとGitディレクトリを返す
+func findGitDir(dir string) (string, string) {
+	dir = filepath.Clean(dir)
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if stat, err := os.Stat(dotGit); err == nil {
+			if stat.IsDir() {
+				return dir, dotGit
+			}
+			// worktreeやsubmoduleでは.gitは"gitdir: <path>"を含むファイル
+			if gitDir := readGitDirFile(dotGit); gitDir != "" {
+				return dir, gitDir
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// readGitDirFile .gitファイルからGitディレクトリのパスを読み取る
+func readGitDirFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if !ok {
+		return ""
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+	return gitDir
+}
+
+// resolveRef refをコミットハッシュに解決（見つからなければ空文字列）
+func resolveRef(gitDir, ref string) string {
+	dirs := []string{gitDir}
+	// worktreeのブランチはcommondirが指す共有ディレクトリにある
+	if common, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir := strings.TrimSpace(string(common))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+		dirs = append(dirs, commonDir)
+	}
+
+	for _, dir := range dirs {
+		if data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(ref))); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	for _, dir := range dirs {
+		if commit := lookupPackedRef(filepath.Join(dir, "packed-refs"), ref); commit != "" {
+			return commit
+		}
+	}
+	return ""
+}
+
+// lookupPackedRef packed-refsからrefのコミットハッシュを探す
+func lookupPackedRef(path, ref string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		commit, name, ok := strings.Cut(scanner.Text(), " ")
+		if ok && name == ref {
+			return commit
+		}
+	}
+	return ""
+}
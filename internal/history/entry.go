@@ -1,21 +1,123 @@
 package history
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
 	"time"
+	"unicode/utf8"
+
+	"github.com/MRyutaro/rrk/internal/shellparse"
 )
 
 // Entry 単一の履歴エントリを表す
 type Entry struct {
-	ID        int       `json:"id"`
-	SessionID string    `json:"session_id"`
-	CWD       string    `json:"cwd"`
-	Command   string    `json:"command"`
-	Timestamp time.Time `json:"timestamp"`
+	ID        int           `json:"id"`
+	SessionID string        `json:"session_id"`
+	CWD       string        `json:"cwd"`
+	Command   string        `json:"command"`
+	Timestamp time.Time     `json:"timestamp"`
+	ExitCode  *int          `json:"exit_code,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	RepoRoot  string        `json:"repo_root,omitempty"`
+	Branch    string        `json:"branch,omitempty"`
+	Commit    string        `json:"commit,omitempty"`
+
+	Hostname     string `json:"hostname,omitempty"`
+	Username     string `json:"username,omitempty"`
+	Shell        string `json:"shell,omitempty"`
+	ShellVersion string `json:"shell_version,omitempty"`
+	TTY          string `json:"tty,omitempty"`
+	TmuxPane     string `json:"tmux_pane,omitempty"`
+
+	// Truncated 長すぎるコマンドを記録時に切り詰めたか
+	Truncated bool `json:"truncated,omitempty"`
+
+	// ImportKey 他のシェル履歴から取り込んだエントリの重複判定用キー
+	ImportKey string `json:"import_key,omitempty"`
+
+	// RecordID デーモンへの記録要求ごとの識別子（応答が届かず直接書き込んだ場合などに重複を避ける）
+	RecordID string `json:"record_id,omitempty"`
+
+	// PrevHash, Hash 監査モードで記録したエントリのハッシュチェーン（直前のエントリのハッシュと、このエントリのハッシュ）
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// Failed コマンドが非ゼロの終了ステータスで終了したかを返す
+func (e *Entry) Failed() bool {
+	return e.ExitCode != nil && *e.ExitCode != 0
+}
+
+// ExecDir コマンドを実行したディレクトリを返す
+// "cd build && make" のように先頭でcdしている場合は、ツリーでの分類と同じくcd先のディレクトリを返す
+func (e *Entry) ExecDir(homeDir string) string {
+	if dir, rest, ok := shellparse.ResolveLeadingCD(e.Command, e.CWD, homeDir); ok && rest != "" {
+		return dir
+	}
+	return e.CWD
+}
+
+// ChainHash Hash以外の全ての項目（PrevHashを含む）のJSONからSHA-256を求め、16進数で返す
+// PrevHashを含めることで、途中のエントリを編集・削除するとそれ以降のハッシュが一致しなくなる
+func (e *Entry) ChainHash() (string, error) {
+	copied := *e
+	copied.Hash = ""
+	data, err := json.Marshal(&copied)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// TruncateCommand コマンドがmaxBytesを超える場合、UTF-8の文字境界で切り詰めて印を付ける
+// maxBytesが0以下なら何もしない。切り詰めた場合はtrueを返す
+func (e *Entry) TruncateCommand(maxBytes int) bool {
+	if maxBytes <= 0 || len(e.Command) <= maxBytes {
+		return false
+	}
+
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(e.Command[cut]) {
+		cut--
+	}
+	e.Command = e.Command[:cut]
+	e.Truncated = true
+	return true
 }
 
 // EntryFilter 履歴エントリをフィルタリングするための条件を含む
+// nilやゼロ値の条件は無視される
 type EntryFilter struct {
-	SessionID *string
-	CWD       *string
-	Limit     int
+	SessionID   *string
+	SessionIDs  []string // nilでなければ、いずれかのセッションに一致するもののみ
+	CWD         *string
+	CWDPrefix   *string // このディレクトリ以下（サブツリー全体）で実行されたもののみ（CWDとともに先頭のcdを解決して判定）
+	Failed      bool
+	ExitCode    *int
+	MinDuration time.Duration
+	RepoRoot    *string
+	Branch      *string
+	Hostname    *string
+	Username    *string
+	Shell       *string
+	TTY         *string
+	TmuxPane    *string
+
+	// Since この時刻以降（含む）、Until この時刻より前に実行されたもののみ
+	Since time.Time
+	Until time.Time
+
+	// CommandContains コマンドにこの文字列を含むもののみ
+	CommandContains string
+	// CommandRegex コマンドがこの正規表現にマッチするもののみ
+	CommandRegex *regexp.Regexp
+
+	// Newest 新しい順に返す（LimitとOffsetも新しい側から数える）
+	Newest bool
+	// Offset 一致したもののうち先頭から読み飛ばす件数
+	Offset int
+	Limit  int
 }
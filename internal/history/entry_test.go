@@ -0,0 +1,62 @@
+package history
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   string
+		maxBytes  int
+		want      string
+		truncated bool
+	}{
+		{"short", "echo hi", 100, "echo hi", false},
+		{"exact", "echo hi", 7, "echo hi", false},
+		{"disabled", strings.Repeat("y", 5000), 0, strings.Repeat("y", 5000), false},
+		{"long", "echo " + strings.Repeat("y", 5000), 1000, "echo " + strings.Repeat("y", 995), true},
+		// "あ" は3バイトなので、4バイト目で切ると文字の途中になる
+		{"rune boundary", "ああああ", 4, "あ", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := Entry{Command: tt.command}
+			truncated := entry.TruncateCommand(tt.maxBytes)
+			if truncated != tt.truncated || entry.Truncated != tt.truncated {
+				t.Errorf("truncated = %v (flag %v), want %v", truncated, entry.Truncated, tt.truncated)
+			}
+			if entry.Command != tt.want {
+				t.Errorf("command has %d bytes, want %d", len(entry.Command), len(tt.want))
+			}
+			if tt.maxBytes > 0 && len(entry.Command) > tt.maxBytes {
+				t.Errorf("command has %d bytes, over the %d byte cap", len(entry.Command), tt.maxBytes)
+			}
+			if !utf8.ValidString(entry.Command) {
+				t.Error("truncated command is not valid UTF-8")
+			}
+		})
+	}
+}
+
+func TestExecDir(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"make", "/home/dev/app"},
+		{"cd build && make", "/home/dev/app/build"},
+		{"cd ~/src; ls", "/home/dev/src"},
+		// cdだけのコマンドや、cdの成否で変わるコマンドは記録したディレクトリのまま
+		{"cd build", "/home/dev/app"},
+		{"cd build || exit", "/home/dev/app"},
+	}
+	for _, tt := range tests {
+		entry := Entry{CWD: "/home/dev/app", Command: tt.command}
+		if got := entry.ExecDir("/home/dev"); got != tt.want {
+			t.Errorf("ExecDir(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
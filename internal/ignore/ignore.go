@@ -0,0 +1,177 @@
+package ignore
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/shellparse"
+)
+
+// ルールの種類
+const (
+	KindSpace = "space"
+	KindWord  = "word"
+	KindGlob  = "glob"
+	KindRegex = "regex"
+	// KindRRK 引数に秘密情報を取るrrkのサブコマンド（設定に関係なく常に除外する）
+	KindRRK = "rrk"
+)
+
+// secretSubcommands 引数に秘密情報を取るrrkのサブコマンド
+// "rrk forget 'hunter2'" を記録すると、消したはずの秘密情報が履歴に戻ってしまう
+var secretSubcommands = []string{"forget", "redact"}
+
+// rrkValueFlags 値を取るrrkのグローバルフラグ
+var rrkValueFlags = map[string]bool{"--data-dir": true, "--profile": true}
+
+// Rule コマンドを記録対象外にする単一のルール
+type Rule struct {
+	Kind    string
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// String ルールを表示用に整形
+func (r Rule) String() string {
+	switch r.Kind {
+	case KindSpace:
+		return "leading space"
+	case KindRRK:
+		return fmt.Sprintf("built-in rule for \"rrk %s\"", r.Pattern)
+	}
+	return fmt.Sprintf("%s %q", r.Kind, r.Pattern)
+}
+
+// match コマンドがルールに一致するか判定
+func (r Rule) match(command string) bool {
+	switch r.Kind {
+	case KindSpace:
+		return strings.HasPrefix(command, " ") || strings.HasPrefix(command, "\t")
+	case KindWord:
+		fields := strings.Fields(command)
+		return len(fields) > 0 && fields[0] == r.Pattern
+	case KindRRK:
+		return rrkSubcommand(command) == r.Pattern
+	default:
+		return r.re.MatchString(strings.TrimSpace(command))
+	}
+}
+
+// Matcher 設定されたルールを順に評価する
+type Matcher struct {
+	rules []Rule
+}
+
+// New 設定からMatcherを作成
+func New(cfg config.IgnoreConfig) (*Matcher, error) {
+	m := &Matcher{}
+
+	if cfg.IgnoreSpace {
+		m.rules = append(m.rules, Rule{Kind: KindSpace})
+	}
+	for _, word := range cfg.Words {
+		m.rules = append(m.rules, Rule{Kind: KindWord, Pattern: word})
+	}
+	for _, glob := range cfg.Globs {
+		re, err := regexp.Compile(globToRegexp(glob))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore glob %q: %w", glob, err)
+		}
+		m.rules = append(m.rules, Rule{Kind: KindGlob, Pattern: glob, re: re})
+	}
+	for _, pattern := range cfg.Regexes {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore regex %q: %w", pattern, err)
+		}
+		m.rules = append(m.rules, Rule{Kind: KindRegex, Pattern: pattern, re: re})
+	}
+	for _, subcommand := range secretSubcommands {
+		m.rules = append(m.rules, Rule{Kind: KindRRK, Pattern: subcommand})
+	}
+
+	return m, nil
+}
+
+// Match コマンドに最初に一致したルールを返す
+func (m *Matcher) Match(command string) (Rule, bool) {
+	for _, rule := range m.rules {
+		if rule.match(command) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// MatchCommand 記録時と同じ判定を行う。"cd build && make" は先頭のcdを除いた "make" でルールを評価する
+// cwdとhomeDirは先頭のcdの移動先を解決するために使う
+func (m *Matcher) MatchCommand(command, cwd, homeDir string) (Rule, bool) {
+	return m.Match(StripLeadingCD(command, cwd, homeDir))
+}
+
+// StripLeadingCD 先頭のcdを除いたコマンドを返す（cdだけのコマンドはそのまま返す）
+// 先頭の空白はignore_spaceの判定のため残す
+func StripLeadingCD(command, cwd, homeDir string) string {
+	if _, rest, ok := shellparse.ResolveLeadingCD(command, cwd, homeDir); ok && rest != "" {
+		leading := command[:len(command)-len(strings.TrimLeft(command, " \t"))]
+		return leading + rest
+	}
+	return command
+}
+
+// rrkSubcommand rrkの実行であればサブコマンド名を返す（グローバルフラグは読み飛ばす）
+func rrkSubcommand(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 || filepath.Base(fields[0]) != "rrk" {
+		return ""
+	}
+	for i := 1; i < len(fields); i++ {
+		switch {
+		case rrkValueFlags[fields[i]]:
+			i++
+		case strings.HasPrefix(fields[i], "-"):
+		default:
+			return fields[i]
+		}
+	}
+	return ""
+}
+
+// Rules 評価順のルール一覧を返す
+func (m *Matcher) Rules() []Rule {
+	return m.rules
+}
+
+// globToRegexp globパターンをコマンド全体にマッチする正規表現に変換
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	// 複数行のコマンドにもマッチするよう.に改行を含める
+	b.WriteString("(?s)^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			// 文字クラスはそのまま正規表現として扱う
+			if end := strings.IndexByte(glob[i+1:], ']'); end >= 0 {
+				class := glob[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				b.WriteString("[" + class + "]")
+				i += end + 1
+				continue
+			}
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package ignore
+
+import (
+	"testing"
+
+	"github.com/MRyutaro/rrk/internal/config"
+)
+
+func TestMatchCommandStripsLeadingCD(t *testing.T) {
+	m, err := New(config.IgnoreConfig{
+		Words:       []string{"make"},
+		Globs:       []string{"cd *"},
+		IgnoreSpace: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		// 先頭のcdを除いた部分で判定する
+		{"cd build && make", KindWord},
+		{"cd build; ls", ""},
+		// cdだけのコマンドはそのまま判定する
+		{"cd build", KindGlob},
+		// 先頭の空白は除かずにignore_spaceで判定する
+		{" cd build && ls", KindSpace},
+		{"ls", ""},
+	}
+	for _, tt := range tests {
+		rule, ignored := m.MatchCommand(tt.command, "/home/dev", "/home/dev")
+		got := ""
+		if ignored {
+			got = rule.Kind
+		}
+		if got != tt.want {
+			t.Errorf("MatchCommand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestSecretSubcommandsAreAlwaysIgnored(t *testing.T) {
+	m, err := New(config.IgnoreConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		command string
+		ignored bool
+	}{
+		{"rrk forget 'hunter2'", true},
+		{"rrk --data-dir /tmp/h forget --regex 'mysql .*-p\\S+'", true},
+		{"/usr/local/bin/rrk --profile=work redact 'token=abc'", true},
+		{"cd ~/src && rrk forget hunter2", true},
+		{"rrk search forget", false},
+		{"rrk tree", false},
+		{"echo rrk forget", false},
+	}
+	for _, tt := range tests {
+		if _, ignored := m.MatchCommand(tt.command, "/home/dev", "/home/dev"); ignored != tt.ignored {
+			t.Errorf("MatchCommand(%q) ignored = %v, want %v", tt.command, ignored, tt.ignored)
+		}
+	}
+}
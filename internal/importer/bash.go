@@ -0,0 +1,35 @@
+package importer
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseBash ~/.bash_history を解析
+// HISTTIMEFORMATが設定されている場合に書き込まれる "#<epoch>" 行を直後のコマンドの時刻として扱う
+func ParseBash(r io.Reader) ([]Record, error) {
+	var records []Record
+	var timestamp time.Time
+
+	err := readLines(r, func(line string) {
+		if epoch, ok := strings.CutPrefix(line, "#"); ok {
+			if seconds, err := strconv.ParseInt(epoch, 10, 64); err == nil {
+				timestamp = time.Unix(seconds, 0)
+				return
+			}
+		}
+		if strings.TrimSpace(line) == "" {
+			return
+		}
+
+		records = append(records, Record{Command: line, Timestamp: timestamp})
+		timestamp = time.Time{}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
@@ -0,0 +1,212 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 他ツールのエクスポートで使われる列名の別名
+var fieldAliases = map[string][]string{
+	"command":   {"command", "cmd"},
+	"cwd":       {"cwd", "directory", "dir"},
+	"exit":      {"exit", "exit_code", "status"},
+	"duration":  {"duration"},
+	"timestamp": {"timestamp", "time", "when"},
+	"hostname":  {"hostname", "host"},
+}
+
+// ParseJSON JSON配列またはJSON Lines形式のエクスポートを解析
+// 各オブジェクトは command, cwd, exit, duration, timestamp, hostname（および別名）を持てる
+func ParseJSON(r io.Reader) ([]Record, error) {
+	reader := bufio.NewReader(r)
+	decoder := json.NewDecoder(reader)
+
+	// 先頭が [ なら配列、そうでなければオブジェクトの連続として読む
+	first, err := peekNonSpace(reader)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	}
+
+	var records []Record
+	for decoder.More() {
+		var object map[string]json.RawMessage
+		if err := decoder.Decode(&object); err != nil {
+			return nil, fmt.Errorf("invalid JSON record %d: %w", len(records)+1, err)
+		}
+
+		fields := make(map[string]string, len(object))
+		for name, raw := range object {
+			fields[strings.ToLower(name)] = rawJSONString(raw)
+		}
+		record, err := recordFromFields(fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON record %d: %w", len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// ParseCSV ヘッダー行付きのCSVエクスポートを解析（列名はParseJSONと同じ）
+func ParseCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+	}
+
+	var records []Record
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		fields := make(map[string]string, len(header))
+		for i, value := range row {
+			if i < len(header) {
+				fields[header[i]] = value
+			}
+		}
+		record, err := recordFromFields(fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV row %d: %w", len(records)+2, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// recordFromFields 列名と値の組からRecordを組み立てる
+func recordFromFields(fields map[string]string) (Record, error) {
+	lookup := func(name string) string {
+		for _, alias := range fieldAliases[name] {
+			if value, ok := fields[alias]; ok {
+				return value
+			}
+		}
+		return ""
+	}
+
+	record := Record{
+		Command:  lookup("command"),
+		CWD:      lookup("cwd"),
+		Hostname: lookup("hostname"),
+	}
+	if record.Command == "" {
+		return record, fmt.Errorf("missing command")
+	}
+
+	if value := lookup("exit"); value != "" {
+		exitCode, err := strconv.Atoi(value)
+		if err != nil {
+			return record, fmt.Errorf("invalid exit status %q", value)
+		}
+		record.ExitCode = &exitCode
+	}
+
+	if value := lookup("duration"); value != "" {
+		duration, err := parseDuration(value)
+		if err != nil {
+			return record, err
+		}
+		record.Duration = duration
+	}
+
+	if value := lookup("timestamp"); value != "" {
+		timestamp, err := parseTimestamp(value)
+		if err != nil {
+			return record, err
+		}
+		record.Timestamp = timestamp
+	}
+
+	return record, nil
+}
+
+// parseDuration ナノ秒の整数（atuinやrrk exportの形式）または "1.5s" のような文字列を解釈
+func parseDuration(value string) (time.Duration, error) {
+	if nanos, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(nanos), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return duration, nil
+}
+
+// parseTimestamp RFC3339形式またはUNIX時刻（秒・ミリ秒・ナノ秒）を解釈
+func parseTimestamp(value string) (time.Time, error) {
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		switch {
+		case epoch > 1e17:
+			return time.Unix(0, epoch), nil
+		case epoch > 1e11:
+			return time.UnixMilli(epoch), nil
+		default:
+			return time.Unix(epoch, 0), nil
+		}
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+	}
+	return timestamp, nil
+}
+
+// rawJSONString JSONの値を文字列として取り出す（文字列以外はそのままの表記）
+func rawJSONString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if bytes.Equal(raw, []byte("null")) {
+		return ""
+	}
+	return string(raw)
+}
+
+// peekNonSpace 空白を読み飛ばし、次の1バイトを消費せずに返す
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			return b[0], nil
+		}
+		if _, err := reader.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package importer
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseFish fishの履歴ファイル（~/.local/share/fish/fish_history）を解析
+//
+//	# fish_history
+//	- cmd: git status
+//	  when: 1700000000
+//	  paths:
+//	    - src/main.go
+//
+// fishは実行ディレクトリを記録しないため、paths:（引数に含まれたパス）は使わない
+func ParseFish(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	err := readLines(r, func(line string) {
+		switch {
+		case strings.HasPrefix(line, "- cmd: "):
+			command := unescapeFish(strings.TrimPrefix(line, "- cmd: "))
+			records = append(records, Record{Command: command})
+		case strings.HasPrefix(line, "  when: ") && len(records) > 0:
+			if seconds, err := strconv.ParseInt(strings.TrimPrefix(line, "  when: "), 10, 64); err == nil {
+				records[len(records)-1].Timestamp = time.Unix(seconds, 0)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// unescapeFish fishが履歴に書き込む際のエスケープ（\\ と \n）を戻す
+func unescapeFish(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
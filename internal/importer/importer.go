@@ -0,0 +1,58 @@
+package importer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Record 他のシェル履歴から読み込んだ1件のコマンド
+type Record struct {
+	Command   string
+	Timestamp time.Time
+	Duration  time.Duration
+	CWD       string
+	ExitCode  *int
+	Hostname  string
+}
+
+// Keys 各レコードの重複判定用キーを返す
+// 同じ時刻・同じコマンドが複数回ある場合は出現順で区別するため、再インポートしても同じキーになる
+// 時刻のないレコードも同じコマンドを出現順で区別し、繰り返し実行したコマンドを1件にまとめない
+// （そのためファイルの先頭が切り詰められると、時刻のないレコードは取り込み直される場合がある）
+func Keys(format string, records []Record) []string {
+	seen := make(map[string]int)
+	keys := make([]string, len(records))
+	for i, record := range records {
+		base := fmt.Sprintf("%s\x00%d\x00%s", format, record.Timestamp.Unix(), record.Command)
+		if record.Timestamp.IsZero() {
+			base = fmt.Sprintf("%s\x00\x00%s", format, record.Command)
+		}
+		seen[base]++
+
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", base, seen[base])))
+		keys[i] = format + ":" + hex.EncodeToString(sum[:12])
+	}
+	return keys
+}
+
+// readLines 長さに制限なく1行ずつ読み込む
+func readLines(r io.Reader, fn func(line string)) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			fn(strings.TrimRight(line, "\r\n"))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package importer
+
+import (
+	"testing"
+	"time"
+)
+
+// TestKeys 同じコマンドの繰り返しを出現順で区別し、同じファイルを取り込み直すと同じキーになることを確かめる
+// 時刻のあるレコードは、ファイルの先頭が切り詰められた後に取り込み直しても同じキーになる
+func TestKeys(t *testing.T) {
+	at := time.Unix(1714560000, 0)
+	full := []Record{
+		{Command: "ls"},
+		{Command: "make"},
+		{Command: "ls"},
+		{Command: "git status", Timestamp: at},
+		{Command: "git status", Timestamp: at},
+	}
+	keys := Keys("bash", full)
+	known := make(map[string]bool)
+	for _, key := range keys {
+		known[key] = true
+	}
+	if len(known) != len(full) {
+		t.Fatalf("got %d distinct keys, want %d (repeated commands are told apart)", len(known), len(full))
+	}
+
+	for i, key := range Keys("bash", full) {
+		if key != keys[i] {
+			t.Errorf("record %d (%q) got a different key on the second import", i, full[i].Command)
+		}
+	}
+
+	truncated := full[3:]
+	for i, key := range Keys("bash", truncated) {
+		if !known[key] {
+			t.Errorf("record %d (%q) got a new key after truncation", i, truncated[i].Command)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zshMeta zshが履歴ファイル内の特殊なバイトをエスケープする際の前置バイト
+const zshMeta = 0x83
+
+// ParseZsh ~/.zsh_history を解析
+// 拡張形式 ": <開始時刻>:<実行秒数>;<コマンド>" と、行末の "\" で続く複数行コマンドに対応
+func ParseZsh(r io.Reader) ([]Record, error) {
+	var records []Record
+	var current *Record
+
+	err := readLines(r, func(line string) {
+		line = unmetafy(line)
+
+		if current != nil {
+			// 前の行の続き
+			current.Command += "\n"
+		} else {
+			records = append(records, parseZshHeader(line))
+			current = &records[len(records)-1]
+			line = current.Command
+			current.Command = ""
+		}
+
+		if continued, ok := strings.CutSuffix(line, "\\"); ok {
+			current.Command += continued
+			return
+		}
+		current.Command += line
+		current = nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 空のコマンドを除去
+	result := records[:0]
+	for _, record := range records {
+		if strings.TrimSpace(record.Command) != "" {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+// parseZshHeader 拡張形式の時刻と実行時間を取り出し、Commandに残りの部分を設定
+func parseZshHeader(line string) Record {
+	meta, command, ok := strings.Cut(line, ";")
+	if !ok || !strings.HasPrefix(meta, ": ") {
+		return Record{Command: line}
+	}
+
+	start, elapsed, ok := strings.Cut(strings.TrimPrefix(meta, ": "), ":")
+	if !ok {
+		return Record{Command: line}
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil {
+		return Record{Command: line}
+	}
+
+	record := Record{Command: command, Timestamp: time.Unix(seconds, 0)}
+	if duration, err := strconv.ParseInt(elapsed, 10, 64); err == nil {
+		record.Duration = time.Duration(duration) * time.Second
+	}
+	return record
+}
+
+// unmetafy zshのメタ文字エスケープを元のバイト列に戻す
+func unmetafy(line string) string {
+	if strings.IndexByte(line, zshMeta) < 0 {
+		return line
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == zshMeta && i+1 < len(line) {
+			i++
+			b.WriteByte(line[i] ^ 32)
+			continue
+		}
+		b.WriteByte(line[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,270 @@
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	// HomeEnv データディレクトリを指定する環境変数
+	HomeEnv = "RRK_HOME"
+	// ProfileEnv 使用するプロファイルを指定する環境変数（シェルごとに切り替える）
+	ProfileEnv = "RRK_PROFILE"
+	// DefaultProfile プロファイルを指定しない場合のプロファイル名
+	DefaultProfile = "default"
+)
+
+// profileName プロファイル名に使える文字
+var profileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// --data-dir と --profile で指定された値（空なら環境変数などから決める）
+var (
+	dataDirOverride string
+	profileOverride string
+)
+
+// SetDataDir --data-dir で指定されたデータディレクトリを設定
+func SetDataDir(dir string) {
+	dataDirOverride = dir
+}
+
+// SetProfile --profile で指定されたプロファイルを設定
+func SetProfile(name string) {
+	profileOverride = name
+}
+
+// RootDir 全てのプロファイルで共有するデータディレクトリを返す
+// --data-dir、RRK_HOME、既存の ~/.rrk、$XDG_DATA_HOME/rrk、~/.rrk の順に決める
+func RootDir() (string, error) {
+	dir, _, err := resolveRoot()
+	return dir, err
+}
+
+// ExplicitRootDir --data-dir か RRK_HOME で明示的に指定されたデータディレクトリを返す（指定がなければ空文字列）
+func ExplicitRootDir() (string, error) {
+	if dataDirOverride == "" && os.Getenv(HomeEnv) == "" {
+		return "", nil
+	}
+	return RootDir()
+}
+
+// ConfigDir 設定ファイルを置くディレクトリを返す
+// データディレクトリを $XDG_DATA_HOME から決めた場合は $XDG_CONFIG_HOME/rrk（未設定なら ~/.config/rrk）、それ以外はデータディレクトリ
+func ConfigDir() (string, error) {
+	root, xdg, err := resolveRoot()
+	if err != nil || !xdg {
+		return root, err
+	}
+	if configHome := xdgDir("XDG_CONFIG_HOME"); configHome != "" {
+		return filepath.Join(configHome, "rrk"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".config", "rrk"), nil
+}
+
+// resolveRoot データディレクトリと、それを $XDG_DATA_HOME から決めたかを返す
+func resolveRoot() (string, bool, error) {
+	for _, dir := range []string{dataDirOverride, os.Getenv(HomeEnv)} {
+		if dir != "" {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return "", false, fmt.Errorf("invalid data directory %q: %w", dir, err)
+			}
+			return abs, false, nil
+		}
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	legacy := filepath.Join(homeDir, ".rrk")
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, false, nil
+	}
+	if dataHome := xdgDir("XDG_DATA_HOME"); dataHome != "" {
+		return filepath.Join(dataHome, "rrk"), true, nil
+	}
+	return legacy, false, nil
+}
+
+// xdgDir XDGの環境変数の値を返す（仕様に従い、相対パスは無視する）
+func xdgDir(name string) string {
+	dir := os.Getenv(name)
+	if !filepath.IsAbs(dir) {
+		return ""
+	}
+	return dir
+}
+
+// RuntimeDir ログイン中だけ保持すればよい状態（パスフレーズから導出した鍵のキャッシュなど）を置く、本人専用のディレクトリを作成して返す
+// $XDG_RUNTIME_DIR/rrk、未設定なら一時ディレクトリの rrk-<uid> を使う（どちらも再起動で消える）
+// 他のユーザーが先に作成して書き込めるディレクトリは使わない
+func RuntimeDir() (string, error) {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("rrk-%d", os.Getuid()))
+	if runtimeDir := xdgDir("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		dir = filepath.Join(runtimeDir, "rrk")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to create runtime directory: %w", err)
+	}
+	// Windowsではパーミッションのビットでアクセス権を表さないため、ディレクトリであることだけを確かめる
+	if !info.IsDir() || (runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0) {
+		return "", fmt.Errorf("runtime directory %s is accessible by other users", dir)
+	}
+	return dir, nil
+}
+
+// ValidateProfile プロファイル名として使えるかを確かめる
+func ValidateProfile(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (use letters, digits, '.', '_' and '-')", name)
+	}
+	return nil
+}
+
+// Profile 使用するプロファイル名を返す
+// --profile、RRK_PROFILE、"rrk profile use" で保存したプロファイル、default の順に決める
+func Profile() (string, error) {
+	name := profileOverride
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" {
+		saved, err := savedProfile()
+		if err != nil {
+			return "", err
+		}
+		name = saved
+	}
+	if name == "" {
+		return DefaultProfile, nil
+	}
+	if err := ValidateProfile(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// ExplicitProfile --profile か RRK_PROFILE で明示的に指定されたプロファイルを返す（指定がなければ空文字列）
+func ExplicitProfile() (string, error) {
+	name := profileOverride
+	if name == "" {
+		name = os.Getenv(ProfileEnv)
+	}
+	if name == "" {
+		return "", nil
+	}
+	if err := ValidateProfile(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// DataDir 使用するプロファイルの履歴を保存するディレクトリを返す
+func DataDir() (string, error) {
+	name, err := Profile()
+	if err != nil {
+		return "", err
+	}
+	return ProfileDir(name)
+}
+
+// ProfileDir プロファイルのディレクトリを返す（defaultプロファイルはデータディレクトリそのもの）
+func ProfileDir(name string) (string, error) {
+	root, err := RootDir()
+	if err != nil {
+		return "", err
+	}
+	if name == DefaultProfile {
+		return root, nil
+	}
+	if err := ValidateProfile(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "profiles", name), nil
+}
+
+// Profiles 作成済みのプロファイル名の一覧を返す（defaultは常に含む）
+func Profiles() ([]string, error) {
+	root, err := RootDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "profiles"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read profiles: %w", err)
+	}
+
+	names := []string{DefaultProfile}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != DefaultProfile && ValidateProfile(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names[1:])
+	return names, nil
+}
+
+// profileFile "rrk profile use" で選んだプロファイルを保存するファイルのパスを返す
+func profileFile() (string, error) {
+	root, err := RootDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "profile"), nil
+}
+
+// savedProfile "rrk profile use" で保存したプロファイル名を返す（なければ空文字列）
+func savedProfile() (string, error) {
+	path, err := profileFile()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read the selected profile: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// UseProfile RRK_PROFILE や --profile を指定しない場合に使うプロファイルを保存し、ディレクトリを作成する
+func UseProfile(name string) error {
+	dir, err := ProfileDir(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create profile directory: %w", err)
+	}
+
+	path, err := profileFile()
+	if err != nil {
+		return err
+	}
+	if name == DefaultProfile {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to save the selected profile: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(name+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to save the selected profile: %w", err)
+	}
+	return nil
+}
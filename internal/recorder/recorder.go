@@ -0,0 +1,208 @@
+package recorder
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/gitinfo"
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/ignore"
+	"github.com/MRyutaro/rrk/internal/redact"
+	"github.com/MRyutaro/rrk/internal/session"
+)
+
+// Recorder シェルから渡されたコマンドを、除外ルール・伏せ字化・切り詰めを適用した履歴エントリに変換する
+// 現在時刻・作業ディレクトリ・セッションなどはプロバイダ関数から取得するため、テストや他のツールから差し替えられる
+type Recorder struct {
+	clock      func() time.Time
+	workingDir func() (string, error)
+	homeDir    func() (string, error)
+	sessionID  func() (string, error)
+	metadata   func() session.Metadata
+	repo       func(dir string) *gitinfo.Info
+
+	ignore          *ignore.Matcher
+	redactor        *redact.Redactor
+	maxCommandBytes int
+}
+
+// Option Recorderの設定
+type Option func(*Recorder)
+
+// WithClock 現在時刻を返す関数を設定する
+func WithClock(now func() time.Time) Option {
+	return func(r *Recorder) {
+		r.clock = now
+	}
+}
+
+// WithWorkingDir Command.CWDが空の場合に使う作業ディレクトリを返す関数を設定する
+func WithWorkingDir(getwd func() (string, error)) Option {
+	return func(r *Recorder) {
+		r.workingDir = getwd
+	}
+}
+
+// WithHomeDir 先頭の "cd ~" を解決する際のホームディレクトリを返す関数を設定する
+func WithHomeDir(home func() (string, error)) Option {
+	return func(r *Recorder) {
+		r.homeDir = home
+	}
+}
+
+// WithSession 記録するエントリのセッションIDを返す関数を設定する
+func WithSession(sessionID func() (string, error)) Option {
+	return func(r *Recorder) {
+		r.sessionID = sessionID
+	}
+}
+
+// WithMetadata ホスト名・ユーザー名などの実行環境の情報を返す関数を設定する
+func WithMetadata(metadata func() session.Metadata) Option {
+	return func(r *Recorder) {
+		r.metadata = metadata
+	}
+}
+
+// WithRepo 実行ディレクトリのGitリポジトリの情報を返す関数を設定する
+func WithRepo(detect func(dir string) *gitinfo.Info) Option {
+	return func(r *Recorder) {
+		r.repo = detect
+	}
+}
+
+// WithIgnore 記録しないコマンドの除外ルールを設定する
+func WithIgnore(matcher *ignore.Matcher) Option {
+	return func(r *Recorder) {
+		r.ignore = matcher
+	}
+}
+
+// WithRedactor 保存する前にコマンドから秘密情報を伏せる設定を行う
+func WithRedactor(redactor *redact.Redactor) Option {
+	return func(r *Recorder) {
+		r.redactor = redactor
+	}
+}
+
+// WithMaxCommandBytes コマンドを切り詰める長さを設定する（0以下なら切り詰めない）
+func WithMaxCommandBytes(maxBytes int) Option {
+	return func(r *Recorder) {
+		r.maxCommandBytes = maxBytes
+	}
+}
+
+// New Recorderを作成（指定しないプロバイダは現在のプロセスの環境から取得する）
+func New(opts ...Option) *Recorder {
+	r := &Recorder{
+		clock:      time.Now,
+		workingDir: os.Getwd,
+		homeDir:    os.UserHomeDir,
+		sessionID:  session.GetCurrentSessionID,
+		metadata:   session.CurrentMetadata,
+		repo:       gitinfo.Detect,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Command 記録するコマンドと、シェルから渡された情報
+type Command struct {
+	// Text 実行されたコマンド
+	Text string
+	// CWD コマンド開始時の作業ディレクトリ（空ならWithWorkingDirの関数から取得）
+	CWD string
+	// TTY 空なら実行環境の情報から取得
+	TTY          string
+	Shell        string
+	ShellVersion string
+	// ExitCode 終了ステータス（不明ならnil）
+	ExitCode *int
+	// Start コマンドの開始時刻（不明ならゼロ値）
+	Start time.Time
+	// Duration シェルが直接計測した実行時間（不明ならnil、Startより優先する）
+	Duration *time.Duration
+}
+
+// Entry コマンドを履歴エントリに変換する。除外ルールに一致した場合はnilを返す
+// IDは付けないため、保存時にStorageが割り当てる
+func (r *Recorder) Entry(c Command) (*history.Entry, error) {
+	sessionID, err := r.sessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session ID: %w", err)
+	}
+
+	// フックはコマンド開始時のディレクトリを渡す（コマンド内のcdで移動した後の値ではなく）
+	cwd := c.CWD
+	if cwd == "" {
+		if cwd, err = r.workingDir(); err != nil {
+			return nil, fmt.Errorf("failed to get current directory: %w", err)
+		}
+	}
+
+	// "cd build && make" はcd先のディレクトリで実行されたものとして扱う
+	command := c.Text
+	homeDir, _ := r.homeDir()
+	execDir := (&history.Entry{CWD: cwd, Command: command}).ExecDir(homeDir)
+
+	// 設定された除外ルールに一致するコマンドは記録しない（先頭のcdを除いた部分で判定する）
+	if r.ignore != nil {
+		if _, ignored := r.ignore.MatchCommand(command, cwd, homeDir); ignored {
+			return nil, nil
+		}
+	}
+
+	// ディスクに書き込む前に秘密情報を伏せる
+	if r.redactor != nil {
+		command, _ = r.redactor.Redact(command)
+	}
+
+	now := r.clock()
+	entry := &history.Entry{
+		SessionID: sessionID,
+		CWD:       cwd,
+		Command:   command,
+		Timestamp: now,
+	}
+
+	// 巨大な貼り付けなどで履歴が肥大化しないよう、長すぎるコマンドは切り詰める
+	entry.TruncateCommand(r.maxCommandBytes)
+
+	// 実行環境の情報を記録
+	meta := r.metadata()
+	entry.Hostname = meta.Hostname
+	entry.Username = meta.Username
+	entry.TmuxPane = meta.TmuxPane
+	entry.TTY = c.TTY
+	if entry.TTY == "" {
+		entry.TTY = meta.TTY
+	}
+	entry.Shell = c.Shell
+	entry.ShellVersion = c.ShellVersion
+
+	// Gitリポジトリ内であればブランチとコミットを記録
+	if repo := r.repo(execDir); repo != nil {
+		entry.RepoRoot = repo.Root
+		entry.Branch = repo.Branch
+		entry.Commit = repo.Commit
+	}
+
+	// シェルから渡された終了ステータスと開始時刻を反映
+	entry.ExitCode = c.ExitCode
+	if !c.Start.IsZero() {
+		if duration := now.Sub(c.Start); duration > 0 {
+			entry.Duration = duration
+			entry.Timestamp = c.Start
+		}
+	}
+	if c.Duration != nil {
+		// fishは$CMD_DURATIONでミリ秒単位の実行時間を直接提供する
+		entry.Duration = *c.Duration
+		entry.Timestamp = now.Add(-entry.Duration)
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,73 @@
+package recorder
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/gitinfo"
+	"github.com/MRyutaro/rrk/internal/ignore"
+	"github.com/MRyutaro/rrk/internal/session"
+)
+
+// newTestRecorder 実行環境に依存しないRecorderを作成
+func newTestRecorder(opts ...Option) *Recorder {
+	defaults := []Option{
+		WithClock(func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }),
+		WithWorkingDir(func() (string, error) { return "/work", nil }),
+		WithHomeDir(func() (string, error) { return "/home/dev", nil }),
+		WithSession(func() (string, error) { return "session", nil }),
+		WithMetadata(func() session.Metadata { return session.Metadata{Hostname: "host"} }),
+		WithRepo(func(string) *gitinfo.Info { return nil }),
+	}
+	return New(append(defaults, opts...)...)
+}
+
+func TestEntryTruncatesLongCommands(t *testing.T) {
+	rec := newTestRecorder(WithMaxCommandBytes(1000))
+	command := "echo " + strings.Repeat("y", 5<<20)
+
+	entry, err := rec.Entry(Command{Text: command})
+	if err != nil {
+		t.Fatalf("Entry: %v", err)
+	}
+	if !entry.Truncated {
+		t.Error("entry is not marked as truncated")
+	}
+	if len(entry.Command) != 1000 || !strings.HasPrefix(command, entry.Command) {
+		t.Errorf("command has %d bytes, want the first 1000", len(entry.Command))
+	}
+
+	entry, err = rec.Entry(Command{Text: "echo small"})
+	if err != nil {
+		t.Fatalf("Entry: %v", err)
+	}
+	if entry.Truncated || entry.Command != "echo small" {
+		t.Errorf("short command = %q (truncated %v)", entry.Command, entry.Truncated)
+	}
+}
+
+func TestEntrySkipsSecretSubcommands(t *testing.T) {
+	matcher, err := ignore.New(config.IgnoreConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := newTestRecorder(WithIgnore(matcher))
+
+	// 消したい秘密情報を含むコマンドを記録し直さない
+	for _, command := range []string{"rrk forget 'hunter2'", "cd build && rrk redact 'token=abc'"} {
+		entry, err := rec.Entry(Command{Text: command})
+		if err != nil {
+			t.Fatalf("Entry(%q): %v", command, err)
+		}
+		if entry != nil {
+			t.Errorf("Entry(%q) recorded %q", command, entry.Command)
+		}
+	}
+
+	entry, err := rec.Entry(Command{Text: "rrk tree"})
+	if err != nil || entry == nil {
+		t.Fatalf("Entry(rrk tree) = %v, %v; want an entry", entry, err)
+	}
+}
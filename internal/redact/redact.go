@@ -0,0 +1,106 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/MRyutaro/rrk/internal/config"
+)
+
+// secretGroup 置換対象を示す名前付きグループ。ない場合はマッチ全体を置換
+const secretGroup = "secret"
+
+// builtinPatterns よく使われるトークン形式とkey=value形式の秘密情報
+var builtinPatterns = []string{
+	// GitHubトークン
+	`\b(?P<secret>gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`,
+	// AWSアクセスキーID
+	`\b(?P<secret>(?:AKIA|ASIA)[0-9A-Z]{16})\b`,
+	// Slackトークン
+	`\b(?P<secret>xox[abposr]-[A-Za-z0-9-]{10,})`,
+	// sk-で始まるAPIキー
+	`\b(?P<secret>sk-[A-Za-z0-9_-]{20,})`,
+	// JWT
+	`\b(?P<secret>eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)`,
+	// Authorizationヘッダー
+	`(?i)authorization:\s*(?:bearer|basic|token)\s+(?P<secret>[^\s'"]+)`,
+	// URLに埋め込まれた認証情報
+	`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:(?P<secret>[^@\s/]+)@`,
+	// --password=...
+	`(?i)--password=(?P<secret>'[^']*'|"[^"]*"|[^\s'"]+)`,
+	// mysql -p<password>
+	`\b(?:mysql|mysqldump|mysqladmin|mariadb)\b[^|;&]*?\s-p(?P<secret>[^\s'"]\S*)`,
+	// GITHUB_TOKEN=... のような秘密情報らしい名前への代入
+	`(?i)\b[A-Z0-9_]*(?:TOKEN|SECRET|PASSWORD|PASSWD|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)[A-Z0-9_]*=(?P<secret>'[^']*'|"[^"]*"|[^\s'"]+)`,
+}
+
+// Redactor 検出した秘密情報をプレースホルダーに置き換える
+type Redactor struct {
+	patterns    []*regexp.Regexp
+	placeholder string
+}
+
+// New 設定からRedactorを作成
+func New(cfg config.RedactConfig) (*Redactor, error) {
+	r := &Redactor{placeholder: cfg.Placeholder}
+	if r.placeholder == "" {
+		r.placeholder = config.Default().Redact.Placeholder
+	}
+
+	if !cfg.DisableBuiltin {
+		for _, pattern := range builtinPatterns {
+			r.patterns = append(r.patterns, regexp.MustCompile(pattern))
+		}
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	return r, nil
+}
+
+// Redact 秘密情報を伏せたコマンドと、置換が行われたかを返す
+func (r *Redactor) Redact(command string) (string, bool) {
+	redacted := false
+	for _, re := range r.patterns {
+		var changed bool
+		command, changed = r.replace(re, command)
+		redacted = redacted || changed
+	}
+	return command, redacted
+}
+
+// replace 正規表現の全マッチについて秘密部分を置換
+func (r *Redactor) replace(re *regexp.Regexp, command string) (string, bool) {
+	matches := re.FindAllStringSubmatchIndex(command, -1)
+	if len(matches) == 0 {
+		return command, false
+	}
+
+	group := re.SubexpIndex(secretGroup)
+	var b strings.Builder
+	last := 0
+	changed := false
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if group >= 0 {
+			start, end = match[2*group], match[2*group+1]
+		}
+		// 既に伏せた部分や空のマッチは置換しない
+		if start < 0 || start == end || command[start:end] == r.placeholder {
+			continue
+		}
+		b.WriteString(command[last:start])
+		b.WriteString(r.placeholder)
+		last = end
+		changed = true
+	}
+	b.WriteString(command[last:])
+
+	return b.String(), changed
+}
@@ -0,0 +1,45 @@
+package session
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// Crockford's Base32（I, L, O, U を含まない）
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewID 時刻順に並び、衝突しないセッションIDを生成
+// 先頭48ビットがミリ秒単位の時刻、残り80ビットが乱数（ULIDと同じ構成の26文字）
+func NewID(now time.Time) (string, error) {
+	var data [16]byte
+
+	ms := uint64(now.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		data[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := rand.Read(data[6:]); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	return encodeBase32(data), nil
+}
+
+// encodeBase32 128ビットを5ビットずつ26文字に変換（先頭の2ビットは常に0）
+func encodeBase32(data [16]byte) string {
+	out := make([]byte, 26)
+	var acc uint
+	bits := 2 // 130ビット = 26文字に合わせるため、先頭に2ビットの0を補う
+	pos := 0
+	for _, b := range data {
+		acc = acc<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[pos] = crockford[(acc>>uint(bits))&0x1f]
+			pos++
+		}
+	}
+	return string(out)
+}
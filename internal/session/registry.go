@@ -0,0 +1,288 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/filelock"
+	"github.com/MRyutaro/rrk/internal/paths"
+)
+
+// Info セッションレジストリに保存される1つのシェルセッションの情報
+type Info struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Hostname  string     `json:"hostname,omitempty"`
+	Username  string     `json:"username,omitempty"`
+	Shell     string     `json:"shell,omitempty"`
+	Parent    string     `json:"parent,omitempty"`
+	PID       int        `json:"pid,omitempty"`
+}
+
+// registryDir セッションレジストリのディレクトリ（<データディレクトリ>/sessions）を返す
+// セッションはシェルに対応するため、プロファイルを切り替えても共有する
+func registryDir() (string, error) {
+	root, err := paths.RootDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "sessions"), nil
+}
+
+// lockRegistry レジストリを作成し、他のrrkプロセスとの間で排他ロックを取得する
+// 同じセッションのEndとSetNameなどが同時に書き換えても、一方の変更が失われないようにする
+func lockRegistry() (string, func(), error) {
+	dir, err := registryDir()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", nil, fmt.Errorf("failed to create session registry: %w", err)
+	}
+	unlock, err := filelock.Lock(filepath.Join(dir, "lock"))
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, unlock, nil
+}
+
+// Register セッション情報をレジストリに書き込む
+func Register(info *Info) error {
+	dir, unlock, err := lockRegistry()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return writeInfo(dir, info)
+}
+
+// writeInfo セッション情報を一時ファイル経由でアトミックに書き込む（ロック取得中に呼ぶ）
+// 読み込み中の他のプロセスが書きかけのファイルを見ないようにする
+func writeInfo(dir string, info *Info) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, info.ID+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, info.ID+".json"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return nil
+}
+
+// Lookup レジストリからセッション情報を読み込む（登録がなければnil）
+func Lookup(id string) (*Info, error) {
+	dir, err := registryDir()
+	if err != nil {
+		return nil, err
+	}
+	// IDにパス区切りを含む古い形式などはレジストリに存在しない
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid session file for %s: %w", id, err)
+	}
+	return &info, nil
+}
+
+// List レジストリ内の全セッションを開始時刻順に返す
+func List() ([]Info, error) {
+	dir, err := registryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Info{}, nil
+		}
+		return nil, fmt.Errorf("failed to read session registry: %w", err)
+	}
+
+	var sessions []Info
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		info, err := Lookup(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil || info == nil {
+			continue // 壊れたファイルは無視
+		}
+		sessions = append(sessions, *info)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions, nil
+}
+
+// update レジストリのセッション情報をfnで書き換える（未登録ならIDだけの情報から始める）
+func update(id string, fn func(info *Info)) error {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("invalid session ID %q", id)
+	}
+
+	// 読み込みから書き込みまでの間に他のプロセスが書き換えないようにロックする
+	dir, unlock, err := lockRegistry()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info, err := Lookup(id)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		info = &Info{ID: id}
+	}
+
+	fn(info)
+	return writeInfo(dir, info)
+}
+
+// End セッションの終了時刻を記録
+func End(id string, at time.Time) error {
+	return update(id, func(info *Info) {
+		info.EndedAt = &at
+	})
+}
+
+// SetName セッションに名前を付ける（空文字列で名前を外す）
+func SetName(id, name string) error {
+	return update(id, func(info *Info) {
+		info.Name = name
+	})
+}
+
+// Prune cutoffより前に終了したセッションをレジストリから削除し、削除したセッションを返す（dryRunなら削除しない）
+// 終了していないセッション（長く開いたままのシェルを含む）と、残すセッションから親として参照されているセッションは削除しない
+// 名前の付いたセッションとその子孫も、--session-name で古い履歴を絞り込めるよう残す
+func Prune(cutoff time.Time, dryRun bool) ([]Info, error) {
+	dir, unlock, err := lockRegistry()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	sessions, err := List()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*Info, len(sessions))
+	for i := range sessions {
+		byID[sessions[i].ID] = &sessions[i]
+	}
+
+	// named 自身か祖先に名前が付いているか
+	named := func(info *Info) bool {
+		seen := make(map[string]bool)
+		for info != nil && !seen[info.ID] {
+			if info.Name != "" {
+				return true
+			}
+			seen[info.ID] = true
+			info = byID[info.Parent]
+		}
+		return false
+	}
+
+	expired := make(map[string]bool)
+	for i := range sessions {
+		info := &sessions[i]
+		if info.EndedAt != nil && info.EndedAt.Before(cutoff) && !named(info) {
+			expired[info.ID] = true
+		}
+	}
+	// 残すセッションの親は残す（親を残すとその親も参照されたままになるため、変わらなくなるまで繰り返す）
+	for changed := true; changed; {
+		changed = false
+		for _, info := range sessions {
+			if !expired[info.ID] && expired[info.Parent] {
+				delete(expired, info.Parent)
+				changed = true
+			}
+		}
+	}
+
+	var removed []Info
+	for _, info := range sessions {
+		if !expired[info.ID] {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(filepath.Join(dir, info.ID+".json")); err != nil && !os.IsNotExist(err) {
+				return removed, fmt.Errorf("failed to remove session: %w", err)
+			}
+		}
+		removed = append(removed, info)
+	}
+	return removed, nil
+}
+
+// FindByName 指定した名前のセッションと、そこから起動された子孫セッションのIDを返す
+func FindByName(name string) ([]string, error) {
+	sessions, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string][]string)
+	var queue []string
+	for _, info := range sessions {
+		if info.Parent != "" {
+			children[info.Parent] = append(children[info.Parent], info.ID)
+		}
+		if info.Name == name {
+			queue = append(queue, info.ID)
+		}
+	}
+
+	found := make(map[string]bool)
+	ids := []string{}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if found[id] {
+			continue
+		}
+		found[id] = true
+		ids = append(ids, id)
+		queue = append(queue, children[id]...)
+	}
+	return ids, nil
+}
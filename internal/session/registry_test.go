@@ -0,0 +1,140 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/paths"
+)
+
+// useTempRegistry レジストリを一時ディレクトリに置き、そのパスを返す
+func useTempRegistry(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	t.Setenv(paths.HomeEnv, root)
+	return filepath.Join(root, "sessions")
+}
+
+// TestConcurrentUpdatesKeepBothChanges 同じセッションへのEndとSetNameが同時に走っても、
+// どちらの変更も失われないことを確かめる
+func TestConcurrentUpdatesKeepBothChanges(t *testing.T) {
+	useTempRegistry(t)
+	started := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("s%d", i)
+		if err := Register(&Info{ID: id, StartedAt: started}); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 2)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- End(id, started.Add(time.Hour))
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- SetName(id, "build")
+		}()
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		info, err := Lookup(id)
+		if err != nil {
+			t.Fatalf("Lookup: %v", err)
+		}
+		if info.EndedAt == nil || info.Name != "build" || !info.StartedAt.Equal(started) {
+			t.Fatalf("session %s = %+v, want the end time, the name and the start time", id, info)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := useTempRegistry(t)
+	now := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	cutoff := now.Add(-90 * 24 * time.Hour)
+	old := cutoff.Add(-time.Hour)
+	recent := now.Add(-time.Hour)
+
+	sessions := []Info{
+		{ID: "old-ended", StartedAt: old, EndedAt: &old},
+		// 終了していないセッションは、開始が古くても残す
+		{ID: "old-open", StartedAt: old},
+		{ID: "recent-ended", StartedAt: old, EndedAt: &recent},
+		{ID: "recent-open", StartedAt: recent},
+		// 名前の付いたセッションとその子孫は古くても残す
+		{ID: "old-named", Name: "deploy", StartedAt: old, EndedAt: &old},
+		{ID: "old-child", Parent: "old-named", StartedAt: old, EndedAt: &old},
+		// 残すセッションから参照されている親と、その親も残す
+		{ID: "old-grandparent", StartedAt: old, EndedAt: &old},
+		{ID: "old-parent", Parent: "old-grandparent", StartedAt: old, EndedAt: &old},
+		{ID: "open-child", Parent: "old-parent", StartedAt: old},
+		// 親子ともに削除する場合は、子から参照されていても親を削除できる
+		{ID: "old-ended-parent", StartedAt: old, EndedAt: &old},
+		{ID: "old-ended-child", Parent: "old-ended-parent", StartedAt: old, EndedAt: &old},
+	}
+	for i := range sessions {
+		if err := Register(&sessions[i]); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+	want := map[string]bool{"old-ended": true, "old-ended-parent": true, "old-ended-child": true}
+
+	// ドライランでは削除しない
+	preview, err := Prune(cutoff, true)
+	if err != nil {
+		t.Fatalf("Prune(dry run): %v", err)
+	}
+	if len(preview) != len(want) {
+		t.Errorf("dry run would remove %d sessions, want %d", len(preview), len(want))
+	}
+	if left, _ := List(); len(left) != len(sessions) {
+		t.Fatalf("dry run left %d sessions, want %d", len(left), len(sessions))
+	}
+
+	removed, err := Prune(cutoff, false)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	for _, info := range removed {
+		if !want[info.ID] {
+			t.Errorf("session %s was removed", info.ID)
+		}
+	}
+	if len(removed) != len(want) {
+		t.Errorf("removed %d sessions, want %d", len(removed), len(want))
+	}
+
+	left, err := List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(left) != len(sessions)-len(want) {
+		t.Errorf("%d sessions left, want %d", len(left), len(sessions)-len(want))
+	}
+	for _, info := range left {
+		if want[info.ID] {
+			t.Errorf("session %s was kept", info.ID)
+		}
+	}
+
+	// ロックファイル以外の一時ファイルが残っていない
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(left)+1 {
+		t.Errorf("registry has %d files, want %d sessions and the lock file", len(files), len(left))
+	}
+}
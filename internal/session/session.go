@@ -3,10 +3,36 @@ package session
 import (
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/user"
 	"strings"
+	"time"
 )
 
+// Metadata コマンドを実行した環境の情報
+type Metadata struct {
+	Hostname string
+	Username string
+	TTY      string
+	TmuxPane string
+}
+
+// CurrentMetadata 現在のプロセスから取得できる環境の情報を返す
+func CurrentMetadata() Metadata {
+	hostname, _ := os.Hostname()
+
+	username := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		username = current.Username
+	}
+
+	return Metadata{
+		Hostname: hostname,
+		Username: username,
+		TTY:      os.Getenv("TTY"),
+		TmuxPane: os.Getenv("TMUX_PANE"),
+	}
+}
+
 // GetCurrentSessionID 現在のシェルセッションIDを返す
 func GetCurrentSessionID() (string, error) {
 	// まず環境変数から取得を試行
@@ -28,28 +54,35 @@ func GetCurrentSessionID() (string, error) {
 	return fmt.Sprintf("%d_%s", pid, tty), nil
 }
 
-// InitializeSession 新しいセッションIDを作成して保存
-func InitializeSession() (string, error) {
-	// 一意のセッションIDを生成
-	hostname, _ := os.Hostname()
-	pid := os.Getpid()
-	timestamp := fmt.Sprintf("%d", os.Getpid())
-
-	sessionID := fmt.Sprintf("%s_%d_%s", hostname, pid, timestamp)
-
-	// 子プロセス用に環境変数に設定
-	os.Setenv("RRK_SESSION_ID", sessionID)
-
-	// 永続化のためセッションファイルにも書き込み
-	homeDir, err := os.UserHomeDir()
+// InitializeSession 新しいセッションIDを生成し、レジストリに登録
+// shellとparentは記録用で、空でもよい。pidはシェルのプロセスID（0なら親プロセス）
+func InitializeSession(shell, parent string, pid int) (string, error) {
+	now := time.Now()
+	sessionID, err := NewID(now)
 	if err != nil {
 		return "", err
 	}
 
-	sessionFile := filepath.Join(homeDir, ".rrk", "current_session")
-	if err := os.WriteFile(sessionFile, []byte(sessionID), 0644); err != nil {
+	if pid == 0 {
+		pid = os.Getppid()
+	}
+
+	meta := CurrentMetadata()
+	info := &Info{
+		ID:        sessionID,
+		StartedAt: now,
+		Hostname:  meta.Hostname,
+		Username:  meta.Username,
+		Shell:     shell,
+		Parent:    parent,
+		PID:       pid,
+	}
+	if err := Register(info); err != nil {
 		return "", err
 	}
 
+	// 子プロセス用に環境変数に設定
+	os.Setenv("RRK_SESSION_ID", sessionID)
+
 	return sessionID, nil
 }
@@ -0,0 +1,177 @@
+package shellparse
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ResolveLeadingCD commandの先頭にあるcd/pushdを解決し、残りのコマンドが
+// 実際に実行されたディレクトリと残りのコマンドを返す
+//
+// "cd build && make"、"cd a; cd b; make"、"(cd sub; npm test)" のような単純な形のみ扱い、
+// 変数展開や"cd -"のように静的に解決できないものはokがfalseになる
+func ResolveLeadingCD(command, cwd, homeDir string) (dir string, rest string, ok bool) {
+	rest = strings.TrimSpace(command)
+	if inner, wrapped := unwrapSubshell(rest); wrapped {
+		rest = inner
+	}
+
+	dir = cwd
+	for {
+		word, after := nextWord(rest)
+		if word != "cd" && word != "pushd" {
+			break
+		}
+
+		target, remaining, parsed := parseCDArgs(after)
+		if !parsed {
+			return "", "", false
+		}
+		resolved, resolvable := resolveDir(target, dir, homeDir)
+		if !resolvable {
+			return "", "", false
+		}
+
+		dir = resolved
+		ok = true
+		rest = remaining
+	}
+
+	if !ok {
+		return "", "", false
+	}
+	return filepath.Clean(dir), strings.TrimSpace(rest), true
+}
+
+// unwrapSubshell コマンド全体が()で囲まれている場合は中身を返す
+func unwrapSubshell(command string) (string, bool) {
+	if !strings.HasPrefix(command, "(") || !strings.HasSuffix(command, ")") {
+		return command, false
+	}
+
+	depth := 0
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			// 末尾より前で閉じた場合は "(a) && (b)" のような別の形
+			if depth == 0 && i != len(command)-1 {
+				return command, false
+			}
+		}
+	}
+	if depth != 0 {
+		return command, false
+	}
+	return strings.TrimSpace(command[1 : len(command)-1]), true
+}
+
+// nextWord 先頭の単語と残りの文字列を返す
+func nextWord(s string) (string, string) {
+	s = strings.TrimLeft(s, " \t")
+	end := strings.IndexAny(s, " \t;&|()")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+// parseCDArgs cdの引数と、続く区切り（&& または ;）の後ろのコマンドを返す
+func parseCDArgs(s string) (target string, rest string, ok bool) {
+	s = strings.TrimLeft(s, " \t")
+
+	// -P や -L のようなオプションは読み飛ばす（"-" 単体は cd - なので引数）
+	for strings.HasPrefix(s, "-") && len(s) > 1 && s[1] != ' ' && s[1] != '\t' {
+		option, after := nextWord(s)
+		s = strings.TrimLeft(after, " \t")
+		if option == "--" {
+			break
+		}
+	}
+
+	target, s, ok = parseArg(s)
+	if !ok {
+		return "", "", false
+	}
+
+	s = strings.TrimLeft(s, " \t")
+	switch {
+	case s == "":
+		return target, "", true
+	case strings.HasPrefix(s, "&&"):
+		return target, s[2:], true
+	case strings.HasPrefix(s, ";") && !strings.HasPrefix(s, ";;"):
+		return target, s[1:], true
+	default:
+		// || や | の後ろはcdの成否次第で実行ディレクトリが変わる
+		return "", "", false
+	}
+}
+
+// parseArg クォートとバックスラッシュを解釈して1つの引数を取り出す
+func parseArg(s string) (string, string, bool) {
+	var b strings.Builder
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == ';' || c == '&' || c == '|' || c == ')':
+			return b.String(), s[i:], true
+		case c == '$' || c == '`' || c == '*' || c == '?' || c == '(':
+			// 展開を伴う引数は静的に解決できない
+			return "", "", false
+		case c == '\\' && i+1 < len(s):
+			b.WriteByte(s[i+1])
+			i += 2
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return "", "", false
+			}
+			quoted := s[i+1 : i+1+end]
+			if c == '"' && strings.ContainsAny(quoted, "$`") {
+				return "", "", false
+			}
+			b.WriteString(quoted)
+			i += end + 2
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), "", true
+}
+
+// resolveDir cdの引数を絶対パスに解決
+func resolveDir(target, cwd, homeDir string) (string, bool) {
+	switch {
+	case target == "" || target == "~":
+		return homeDir, homeDir != ""
+	case target == "-":
+		return "", false
+	case strings.HasPrefix(target, "~/"):
+		if homeDir == "" {
+			return "", false
+		}
+		return filepath.Join(homeDir, target[2:]), true
+	case strings.HasPrefix(target, "~"):
+		// ~user は解決しない
+		return "", false
+	case filepath.IsAbs(target):
+		return filepath.Clean(target), true
+	case cwd == "":
+		return "", false
+	default:
+		return filepath.Join(cwd, target), true
+	}
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// ErrAuditMode 監査モードでは記録済みの履歴を書き換えられない
+var ErrAuditMode = errors.New("recorded history cannot be modified in audit mode")
+
+// auditAnchor マニフェストに保存するハッシュチェーンの末尾
+// 末尾のエントリを削除してもチェーン自体は壊れないため、最後に記録したハッシュと照合する
+type auditAnchor struct {
+	LastHash string `json:"last_hash"`
+	LastID   int    `json:"last_id"`
+}
+
+// ChainBreak ハッシュチェーンが最初に途切れた位置
+type ChainBreak struct {
+	// Segment, Line 途切れた行の位置（末尾のエントリが削除された場合は空と0）
+	Segment string
+	Line    int
+	// ID 途切れた位置のエントリのID（読めない行では0）
+	ID     int
+	Reason string
+}
+
+// VerifyResult ハッシュチェーンの検証結果
+type VerifyResult struct {
+	// Entries 読み込んだエントリ数、Chained ハッシュを検証できたエントリ数
+	Entries int
+	Chained int
+	// Head, HeadID チェーンの末尾のエントリのハッシュとID（外部に保存しておき、次回の検証で指定する）
+	Head   string
+	HeadID int
+	// Broken 最初に見つかったチェーンの途切れ（なければnil）
+	Broken *ChainBreak
+}
+
+// SetAudit 監査モードを設定する
+// 監査モードでは保存する各エントリに直前のエントリのハッシュを含め、書き換えや削除を拒否する
+func (s *Storage) SetAudit(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = enabled
+}
+
+// chaining 追記するエントリにハッシュチェーンを付けるかを返す
+// 一度チェーンが始まれば、監査モードを有効にする前に起動したデーモンなど設定の古いプロセスもチェーンを続ける
+func (s *Storage) chaining(m *manifest) bool {
+	return s.audit || m.Audit != nil
+}
+
+// chainEntries 追記するエントリにハッシュチェーンを付け、マニフェストの末尾の情報を更新する（ロック取得中に呼ぶ）
+func (s *Storage) chainEntries(m *manifest, entries []*history.Entry) error {
+	prev, err := s.lastHash(m)
+	if err != nil {
+		return err
+	}
+	return chainFrom(m, prev, entries)
+}
+
+// chainFrom prevに続けてエントリにハッシュチェーンを付け、マニフェストの末尾の情報を更新する
+func chainFrom(m *manifest, prev string, entries []*history.Entry) error {
+	var err error
+	for _, entry := range entries {
+		entry.PrevHash = prev
+		if entry.Hash, err = entry.ChainHash(); err != nil {
+			return fmt.Errorf("failed to hash entry: %w", err)
+		}
+		prev = entry.Hash
+	}
+
+	last := entries[len(entries)-1]
+	m.Audit = &auditAnchor{LastHash: last.Hash, LastID: last.ID}
+	return nil
+}
+
+// lastHash 最後に書き込まれたエントリのハッシュを返す（ハッシュがなければ新しいチェーンを始めるため空文字列）
+// マニフェストではなく実際の行から読むため、追記後にマニフェストの保存が中断されてもチェーンは途切れない
+func (s *Storage) lastHash(m *manifest) (string, error) {
+	for i := len(m.Segments) - 1; i >= 0; i-- {
+		// 作成したばかりの空のセグメントはまだファイルがない
+		if m.Segments[i].Count == 0 {
+			continue
+		}
+		found, hash := false, ""
+		err := s.iterateSegment(m.Segments[i], true, func(line []byte) error {
+			entry, err := s.decodeEntry(line)
+			if err != nil {
+				return nil
+			}
+			found, hash = true, entry.Hash
+			return ErrStop
+		})
+		if err != nil && err != ErrStop {
+			return "", err
+		}
+		if found {
+			return hash, nil
+		}
+	}
+	return "", nil
+}
+
+// Verify 全てのエントリを古い順に読み、ハッシュチェーンが途切れている最初の位置を報告する
+// チェーンが始まった後のエントリの編集・削除・挿入と、マニフェストに記録した末尾より後のエントリの削除を検出する
+//
+// ハッシュには鍵がなく、マニフェストも書き換えられるため、履歴を書き換えられる人がチェーン全体を計算し直すと検出できない。
+// 以前の検証で得たHeadを履歴と別の場所に保存しておき、headに指定すると、そのエントリがチェーンに残っているかも確かめる
+func (s *Storage) Verify(ctx context.Context, head string) (*VerifyResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, err := s.manifestForRead()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{}
+	prev, started := "", false
+	anchored := m.Audit == nil
+	headFound := head == ""
+	for _, seg := range m.Segments {
+		reader, _, err := s.openSegment(seg)
+		if err != nil {
+			return nil, err
+		}
+
+		err = forEachNumberedLine(reader, func(line []byte, number int) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			broken := func(id int, reason string) error {
+				result.Broken = &ChainBreak{Segment: seg.Name, Line: number, ID: id, Reason: reason}
+				return ErrStop
+			}
+
+			entry, err := s.decodeEntry(line)
+			if err != nil {
+				if started {
+					return broken(0, "the line is not a valid entry: "+err.Error())
+				}
+				return nil
+			}
+			result.Entries++
+
+			switch {
+			case entry.Hash == "" && !started:
+				// 監査モードを有効にする前のエントリ
+				return nil
+			case entry.Hash == "":
+				return broken(entry.ID, "the entry has no hash (it was added outside audit mode)")
+			case !started && entry.PrevHash != "":
+				return broken(entry.ID, "the chain does not start here (earlier entries were deleted)")
+			case started && entry.PrevHash != prev:
+				return broken(entry.ID, "the previous hash does not match (the entry before it was deleted, edited or inserted)")
+			}
+
+			hash, err := entry.ChainHash()
+			if err != nil {
+				return err
+			}
+			if hash != entry.Hash {
+				return broken(entry.ID, "the hash does not match (the entry was edited)")
+			}
+
+			prev, started = entry.Hash, true
+			result.Chained++
+			result.Head, result.HeadID = entry.Hash, entry.ID
+			if m.Audit != nil && entry.Hash == m.Audit.LastHash {
+				anchored = true
+			}
+			if entry.Hash == head {
+				headFound = true
+			}
+			return nil
+		})
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify history segment %s: %w", seg.Name, err)
+		}
+		if result.Broken != nil {
+			return result, nil
+		}
+	}
+
+	switch {
+	case !headFound:
+		result.Broken = &ChainBreak{
+			Reason: fmt.Sprintf("the chain does not contain the head %s (the history was rewritten or its newest entries were deleted)", head),
+		}
+	case !anchored:
+		result.Broken = &ChainBreak{
+			ID:     m.Audit.LastID,
+			Reason: fmt.Sprintf("the chain ends before entry %d (the newest entries were deleted)", m.Audit.LastID),
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// saveCommands コマンドを1件ずつ記録する
+func saveCommands(t *testing.T, store *Storage, commands ...string) {
+	t.Helper()
+	for _, command := range commands {
+		if err := store.Save(&history.Entry{SessionID: "audit", CWD: "/tmp", Command: command}); err != nil {
+			t.Fatalf("Save(%q): %v", command, err)
+		}
+	}
+}
+
+// TestVerifyHeadDetectsRecomputedChain 履歴を書き換えてチェーンとマニフェストを計算し直しても、
+// 外部に保存した先頭のハッシュを指定すれば検出できることを確かめる
+func TestVerifyHeadDetectsRecomputedChain(t *testing.T) {
+	store, err := Open(t.TempDir(), WithAudit(true))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveCommands(t, store, "echo one", "echo two", "echo three")
+
+	before, err := store.Verify(context.Background(), "")
+	if err != nil || before.Broken != nil || before.Chained != 3 || before.HeadID != 3 {
+		t.Fatalf("Verify = %+v, %v, want an intact chain of 3 ending at ID 3", before, err)
+	}
+
+	// 2件目を書き換え、以降のハッシュとマニフェストの末尾を計算し直す
+	m, err := store.loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := ""
+	err = store.rewriteSegments(m, func(entry *history.Entry) bool {
+		if entry.ID == 2 {
+			entry.Command = "echo innocent"
+		}
+		entry.PrevHash = prev
+		entry.Hash, _ = entry.ChainHash()
+		prev = entry.Hash
+		m.Audit = &auditAnchor{LastHash: entry.Hash, LastID: entry.ID}
+		return true
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := store.Verify(context.Background(), "")
+	if err != nil || after.Broken != nil {
+		t.Fatalf("Verify without head = %+v, %v; a recomputed chain is expected to pass", after, err)
+	}
+	after, err = store.Verify(context.Background(), before.Head)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if after.Broken == nil || !strings.Contains(after.Broken.Reason, before.Head) {
+		t.Errorf("Verify with head = %+v, want a break mentioning the head", after.Broken)
+	}
+
+	// 正しい先頭のハッシュ（とそれより前のハッシュ）は検証を通る
+	saveCommands(t, store, "echo four")
+	if result, err := store.Verify(context.Background(), after.Head); err != nil || result.Broken != nil {
+		t.Errorf("Verify with the current head = %+v, %v", result.Broken, err)
+	}
+}
+
+// TestChainContinuesWithoutAuditSetting 監査モードを知らないプロセス（設定を読み込む前に起動したデーモンなど）も、
+// チェーンが始まっていれば続けることを確かめる
+func TestChainContinuesWithoutAuditSetting(t *testing.T) {
+	dir := t.TempDir()
+	audited, err := Open(dir, WithAudit(true))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveCommands(t, audited, "echo audited")
+
+	stale, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveCommands(t, stale, "echo from a stale process")
+
+	result, err := audited.Verify(context.Background(), "")
+	if err != nil || result.Broken != nil || result.Chained != 2 {
+		t.Errorf("Verify = %+v, %v, want an intact chain of 2", result, err)
+	}
+}
+
+// TestAuditModeRefusesRewrites 監査モードのStorageと、チェーンが始まった履歴を監査モードの設定なしで開いたStorageの
+// どちらも記録済みの履歴を書き換えないことを確かめる
+func TestAuditModeRefusesRewrites(t *testing.T) {
+	t.Setenv(PassphraseEnv, "")
+	dir := t.TempDir()
+	audited, err := Open(dir, WithAudit(true))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveCommands(t, audited, "echo one", "echo two")
+	unaudited, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for storeName, store := range map[string]*Storage{"audit": audited, "without audit setting": unaudited} {
+		checks := map[string]func() error{
+			"Rewrite": func() error { return store.Rewrite(keepEntry) },
+			"Delete":  func() error { _, err := store.Delete(1); return err },
+			"Replace": func() error { return store.Replace(history.Entry{ID: 1, Command: "echo edited"}) },
+			"Prune": func() error {
+				_, err := store.Prune(context.Background(), history.EntryFilter{}, Retention{MaxEntries: 1}, false, nil)
+				return err
+			},
+			"Repair":  func() error { _, err := store.Repair(context.Background()); return err },
+			"Encrypt": func() error { return store.Encrypt(KeySourceFile) },
+			"Decrypt": func() error { return store.Decrypt() },
+		}
+		for name, run := range checks {
+			if err := run(); !errors.Is(err, ErrAuditMode) {
+				t.Errorf("%s: %s = %v, want ErrAuditMode", storeName, name, err)
+			}
+		}
+	}
+	if _, err := os.Stat(audited.KeyFile()); !os.IsNotExist(err) {
+		t.Error("Encrypt created a key file in audit mode")
+	}
+
+	result, err := audited.Verify(context.Background(), "")
+	if err != nil || result.Broken != nil || result.Chained != 2 {
+		t.Errorf("Verify = %+v, %v, want an intact chain of 2", result, err)
+	}
+}
+
+// TestAuditModeSkipsRetention チェーンが始まった履歴では、監査モードの設定がなくても保持ポリシーを自動で適用しないことを確かめる
+func TestAuditModeSkipsRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	clock := func() time.Time { return now }
+	audited, err := Open(dir, WithAudit(true), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveCommands(t, audited, "echo one", "echo two")
+
+	// 月が変わり、保持ポリシーを設定した（監査モードの設定がない）プロセスが記録する
+	now = now.AddDate(0, 1, 0)
+	store, err := Open(dir, WithClock(clock), WithRetention(Retention{MaxEntries: 1}))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveCommands(t, store, "echo three")
+
+	result, err := store.Verify(context.Background(), "")
+	if err != nil || result.Broken != nil || result.Entries != 3 {
+		t.Errorf("Verify = %+v, %v, want all 3 entries in an intact chain", result, err)
+	}
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// writeCache デーモンのように同じStorageで何度も保存するプロセスが、保存のたびにディスクから読み直さないよう保持する状態
+// マニフェストかIDカウンタを他のプロセス（または書き換え）が変更するまで有効
+type writeCache struct {
+	// stamp 読み込んだ時点、または最後に保存した時点のマニフェストとIDカウンタの更新時刻・サイズ
+	stamp    string
+	manifest *manifest
+	nextID   int
+	// lastHash ハッシュチェーンの末尾（hashLoadedがfalseならまだ読み込んでいない）
+	lastHash   string
+	hashLoaded bool
+	// recent 最近保存したエントリのRecordIDとID（古い順）
+	recent []recordRef
+}
+
+// WithWriteCache 保存に使うマニフェスト・次のID・最近のRecordIDをメモリに保持する
+// 保存のたびにマニフェストの更新時刻を確かめ、他のプロセスが書き込んだ場合だけ読み直す
+// ロックは追記する間だけ取得するため、フックが直接書き込む場合や他のコマンドと並行して使える
+func WithWriteCache() Option {
+	return func(s *Storage) {
+		s.cache = &writeCache{}
+	}
+}
+
+// writeStamp マニフェストとIDカウンタの更新時刻とサイズを返す
+// どちらも保存のたびに書き換えるため、変わっていなければ他のプロセスは書き込んでいない
+func (s *Storage) writeStamp() string {
+	return fileStamp(s.manifestFile()) + "," + fileStamp(s.counterFile())
+}
+
+// fileStamp ファイルの更新時刻とサイズ（なければ空文字列）
+func fileStamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// saveCached メモリに保持した状態を使ってエントリを保存する（ロック取得中に呼ぶ）
+func (s *Storage) saveCached(entries []*history.Entry) error {
+	c := s.cache
+	if c.manifest == nil || c.stamp != s.writeStamp() {
+		if err := s.reloadCache(); err != nil {
+			return err
+		}
+	}
+
+	// 途中で失敗した場合は、次の保存でディスクから読み直す
+	saved := false
+	defer func() {
+		if !saved {
+			c.manifest = nil
+		}
+	}()
+
+	entries = skipRecordIDs(entries, recordIDs(c.recent))
+	if len(entries) == 0 {
+		saved = true
+		return nil
+	}
+
+	nextID, err := s.assignIDs(c.nextID, entries)
+	if err != nil {
+		return err
+	}
+	m := c.manifest
+	seg, err := s.activeSegment(m, s.now())
+	if err != nil {
+		return err
+	}
+
+	if s.chaining(m) {
+		if !c.hashLoaded {
+			if c.lastHash, err = s.lastHash(m); err != nil {
+				return err
+			}
+			c.hashLoaded = true
+		}
+		if err := chainFrom(m, c.lastHash, entries); err != nil {
+			return err
+		}
+	}
+
+	if err := s.appendEntries(seg, entries); err != nil {
+		return err
+	}
+	if err := s.saveManifest(m); err != nil {
+		return err
+	}
+
+	c.nextID = nextID
+	if m.Audit != nil {
+		c.lastHash = m.Audit.LastHash
+	}
+	for _, entry := range entries {
+		if entry.RecordID != "" {
+			c.recent = append(c.recent, recordRef{recordID: entry.RecordID, id: entry.ID})
+		}
+	}
+	if n := len(c.recent); n > recentRecordLines {
+		c.recent = append([]recordRef(nil), c.recent[n-recentRecordLines:]...)
+	}
+	c.stamp = s.writeStamp()
+	saved = true
+	return nil
+}
+
+// reloadCache マニフェスト・IDカウンタ・最近のRecordIDをディスクから読み直す（ロック取得中に呼ぶ）
+func (s *Storage) reloadCache() error {
+	m, err := s.manifestForWrite()
+	if err != nil {
+		return err
+	}
+	nextID, err := s.readCounter(m)
+	if err != nil {
+		return err
+	}
+	// 応答が届かずにフックが直接書き込んだ記録要求も重複と判定できるよう、末尾の行から読み直す
+	recent, err := s.recentRecords(m)
+	if err != nil {
+		return err
+	}
+
+	*s.cache = writeCache{stamp: s.writeStamp(), manifest: m, nextID: nextID, recent: recent}
+	return nil
+}
@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+const (
+	// keySize AES-256の鍵の長さ
+	keySize = 32
+	// passphraseIterations パスフレーズから鍵を導出する際のPBKDF2の反復回数
+	// コマンドを記録する度に導出するため、フックの遅延が目立たない程度に抑える
+	passphraseIterations = 100_000
+	// keyCheckText 鍵が正しいかを確かめるため、暗号化してマニフェストに保存する文字列
+	keyCheckText = "rrk"
+)
+
+// PassphraseEnv パスフレーズで暗号化した履歴の鍵を導出するための環境変数
+const PassphraseEnv = "RRK_PASSPHRASE"
+
+var (
+	// ErrWrongKey 暗号化された履歴の鍵が一致しない
+	ErrWrongKey = errors.New("the key or passphrase does not match the encrypted history")
+	// ErrNoPassphrase パスフレーズで暗号化した履歴だが、RRK_PASSPHRASEが設定されておらず、導出した鍵のキャッシュもない
+	ErrNoPassphrase = errors.New("history is encrypted with a passphrase")
+)
+
+// encryptedPrefix 暗号化された行の先頭（平文のエントリは "{\"id\":" で始まる）
+var encryptedPrefix = []byte(`{"enc":`)
+
+// KeySource 暗号化の鍵をどこから得るか
+type KeySource string
+
+const (
+	// KeySourceFile 鍵ファイル（デフォルトは ~/.rrk/key、WithKeyFileで変更可能）に保存したランダムな鍵
+	KeySourceFile KeySource = "keyfile"
+	// KeySourcePassphrase RRK_PASSPHRASE から導出した鍵
+	KeySourcePassphrase KeySource = "passphrase"
+)
+
+// encryption マニフェストに保存する暗号化の設定（鍵そのものは保存しない）
+type encryption struct {
+	Source     KeySource `json:"source"`
+	Salt       []byte    `json:"salt,omitempty"`
+	Iterations int       `json:"iterations,omitempty"`
+	// Check keyCheckTextを暗号化したもの
+	Check []byte `json:"check"`
+}
+
+// Cipher 履歴の各行をAES-GCMで暗号化・復号する
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher 32バイトの鍵からCipherを作成
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes", keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// seal ランダムなnonceで暗号化し、nonce||暗号文 を返す
+func (c *Cipher) seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// open sealで暗号化したデータを復号し、改ざんされていないかを検証する
+func (c *Cipher) open(data []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.New("encrypted data is too short")
+	}
+	plain, err := c.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt entry: %w", err)
+	}
+	return plain, nil
+}
+
+// WithKeyFile 鍵ファイルのパスを設定する（履歴とは別のディスクなど、データディレクトリの外に鍵を置く場合）
+func WithKeyFile(path string) Option {
+	return func(s *Storage) {
+		s.keyFile = path
+	}
+}
+
+// KeyFile 鍵ファイルで暗号化する場合の鍵ファイルのパスを返す
+func (s *Storage) KeyFile() string {
+	if s.keyFile != "" {
+		return s.keyFile
+	}
+	return filepath.Join(s.basePath, "key")
+}
+
+// readKeyFile 鍵ファイルを読み込む
+func (s *Storage) readKeyFile() ([]byte, error) {
+	key, err := os.ReadFile(s.KeyFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("invalid encryption key %s", s.KeyFile())
+	}
+	return key, nil
+}
+
+// createKeyFile ランダムな鍵を本人のみ読める鍵ファイルに保存する（すでにあれば読み込む）
+func (s *Storage) createKeyFile() ([]byte, error) {
+	if _, err := os.Stat(s.KeyFile()); err == nil {
+		return s.readKeyFile()
+	}
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.KeyFile()), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := writeFileAtomic(s.KeyFile(), key); err != nil {
+		return nil, fmt.Errorf("failed to save encryption key: %w", err)
+	}
+	return key, nil
+}
+
+// passphraseKey パスフレーズとソルトからPBKDF2で鍵を導出する
+func passphraseKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("%w; set %s", ErrNoPassphrase, PassphraseEnv)
+	}
+	return pbkdf2.Key(sha256.New, passphrase, salt, iterations, keySize)
+}
+
+// cipherFor マニフェストの暗号化の設定に合った鍵を読み込み、正しい鍵かを確かめる
+func (s *Storage) cipherFor(enc *encryption) (*Cipher, error) {
+	var key []byte
+	var err error
+	passphrase := os.Getenv(PassphraseEnv)
+	derived := false
+	switch enc.Source {
+	case KeySourceFile:
+		key, err = s.readKeyFile()
+	case KeySourcePassphrase:
+		if key = cachedPassphraseKey(enc, passphrase); key == nil {
+			key, err = passphraseKey(passphrase, enc.Salt, enc.Iterations)
+			derived = true
+		}
+	default:
+		err = fmt.Errorf("unknown encryption key source %q", enc.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := c.open(enc.Check); err != nil || string(check) != keyCheckText {
+		return nil, ErrWrongKey
+	}
+	if derived {
+		s.cachePassphraseKey(enc, key, passphrase)
+	}
+	return c, nil
+}
+
+// syncEncryption マニフェストの暗号化の設定に合わせて鍵を読み込む（他のプロセスが暗号化・復号した場合に追従する）
+func (s *Storage) syncEncryption(m *manifest) error {
+	if m.Encryption == nil {
+		s.cipher = nil
+		return nil
+	}
+	if s.cipher != nil && s.cipherCheck != nil && bytes.Equal(s.cipherCheck, m.Encryption.Check) {
+		return nil
+	}
+	c, err := s.cipherFor(m.Encryption)
+	if err != nil {
+		return err
+	}
+	s.cipher, s.cipherCheck = c, m.Encryption.Check
+	return nil
+}
+
+// manifestForWrite マニフェストを読み込み、暗号化の設定に合わせて鍵を読み込む（書き込み用のロック取得中に呼ぶ）
+func (s *Storage) manifestForWrite() (*manifest, error) {
+	m, err := s.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.syncEncryption(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// manifestForRead マニフェストを読み込む（読み込みロック中は鍵を変更できないため、
+// 読み込み後に他のプロセスが暗号化・復号していた場合は、暗号化された行を無視せずエラーにする）
+func (s *Storage) manifestForRead() (*manifest, error) {
+	m, err := s.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	if m.Encryption != nil && (s.cipher == nil || !bytes.Equal(s.cipherCheck, m.Encryption.Check)) {
+		return nil, errors.New("history was encrypted by another rrk process; run the command again")
+	}
+	return m, nil
+}
+
+// Encrypted 履歴が暗号化されているかを返す
+func (s *Storage) Encrypted() (bool, error) {
+	m, err := s.loadManifest()
+	if err != nil {
+		return false, err
+	}
+	return m.Encryption != nil, nil
+}
+
+// Encrypt 既存の履歴を全て暗号化し、以降に保存するエントリも暗号化する
+// 鍵はsourceに応じて鍵ファイル（なければ作成）か、RRK_PASSPHRASEから導出する
+// 先にマニフェストに設定を保存してから各セグメントを書き換えるため、中断しても平文と暗号文が混在した状態で読み込める
+// 全てのセグメントを書き換えるため、監査モードではErrAuditModeを返す
+func (s *Storage) Encrypt(source KeySource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := s.loadManifest()
+	if err != nil {
+		return err
+	}
+	if s.chaining(m) {
+		return ErrAuditMode
+	}
+	if m.Encryption != nil {
+		return errors.New("history is already encrypted")
+	}
+
+	enc := &encryption{Source: source}
+	var key []byte
+	switch source {
+	case KeySourceFile:
+		key, err = s.createKeyFile()
+	case KeySourcePassphrase:
+		enc.Salt = make([]byte, 16)
+		if _, err := rand.Read(enc.Salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		enc.Iterations = passphraseIterations
+		key, err = passphraseKey(os.Getenv(PassphraseEnv), enc.Salt, enc.Iterations)
+	default:
+		err = fmt.Errorf("unknown encryption key source %q", source)
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if enc.Check, err = c.seal([]byte(keyCheckText)); err != nil {
+		return err
+	}
+
+	m.Encryption = enc
+	if err := s.saveManifest(m); err != nil {
+		return err
+	}
+	if source == KeySourcePassphrase {
+		s.cachePassphraseKey(enc, key, os.Getenv(PassphraseEnv))
+	}
+	s.cipher, s.cipherCheck = c, enc.Check
+	return s.rewriteSegments(m, keepEntry, nil)
+}
+
+// Decrypt 暗号化された履歴を全て平文に戻す
+// 全てのセグメントを平文で書き換えてから、マニフェストの暗号化の設定を削除する（監査モードではErrAuditModeを返す）
+func (s *Storage) Decrypt() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := s.loadManifest()
+	if err != nil {
+		return err
+	}
+	if s.chaining(m) {
+		return ErrAuditMode
+	}
+	if m.Encryption == nil {
+		return errors.New("history is not encrypted")
+	}
+	if err := s.syncEncryption(m); err != nil {
+		return err
+	}
+
+	s.plaintext = true
+	defer func() { s.plaintext = false }()
+	if err := s.rewriteSegments(m, keepEntry, nil); err != nil {
+		return err
+	}
+
+	enc := m.Encryption
+	m.Encryption = nil
+	if err := s.saveManifest(m); err != nil {
+		return err
+	}
+	removeKeyCache(enc)
+	s.cipher, s.cipherCheck = nil, nil
+	return nil
+}
+
+// keepEntry 全てのエントリをそのまま残す（形式だけを書き換える場合に使う）
+func keepEntry(*history.Entry) bool {
+	return true
+}
+
+// openLine 行が暗号化されていれば復号して平文のJSONを返す
+func (s *Storage) openLine(line []byte) (plain []byte, encrypted bool, err error) {
+	if !bytes.HasPrefix(line, encryptedPrefix) {
+		return line, false, nil
+	}
+	var wrapped struct {
+		Enc []byte `json:"enc"`
+	}
+	if err := json.Unmarshal(line, &wrapped); err != nil {
+		return nil, true, err
+	}
+	if s.cipher == nil {
+		return nil, true, errors.New("entry is encrypted but no key is loaded")
+	}
+	plain, err = s.cipher.open(wrapped.Enc)
+	return plain, true, err
+}
+
+// sealLine 暗号化が有効なら平文のJSONを暗号化した行に変換する
+func (s *Storage) sealLine(plain []byte) ([]byte, error) {
+	if !s.encrypting() {
+		return plain, nil
+	}
+	data, err := s.cipher.seal(plain)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Enc []byte `json:"enc"`
+	}{data})
+}
+
+// encrypting 新しく書き込む行を暗号化するかを返す
+func (s *Storage) encrypting() bool {
+	return s.cipher != nil && !s.plaintext
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// storedCommands 保存されているエントリのコマンドを古い順に返す
+func storedCommands(t *testing.T, store *Storage) []string {
+	t.Helper()
+	entries, err := store.Load(history.EntryFilter{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	commands := []string{}
+	for _, entry := range entries {
+		commands = append(commands, entry.Command)
+	}
+	return commands
+}
+
+// historyContains 履歴のファイルのどこかに平文のsが含まれているかを返す（圧縮済みのセグメントは展開して調べる）
+func historyContains(t *testing.T, dir, s string) bool {
+	t.Helper()
+	for path, data := range dirSnapshot(t, filepath.Join(dir, "history")) {
+		if strings.HasSuffix(path, ".gz") {
+			data = readSealed(t, path)
+		}
+		if strings.Contains(data, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestEncryptRoundTrip 鍵ファイルとパスフレーズのそれぞれで、封印済みのセグメントを含む履歴を暗号化・保存・読み込み・復号し、
+// 内容が変わらないことを確かめる（鍵ファイルはデータディレクトリの外に置く）
+func TestEncryptRoundTrip(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv(PassphraseEnv, "correct horse battery staple")
+
+	for _, source := range []KeySource{KeySourceFile, KeySourcePassphrase} {
+		t.Run(string(source), func(t *testing.T) {
+			dir := t.TempDir()
+			keyFile := filepath.Join(t.TempDir(), "keys", "rrk.key")
+			store := openSealedStore(t, dir, WithKeyFile(keyFile))
+			if err := store.Encrypt(source); err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			saveAt(t, store, retentionNow, "s", "/work", "echo after encrypt")
+			if historyContains(t, dir, "echo") {
+				t.Error("encrypted history contains plain commands")
+			}
+
+			_, err := os.Stat(keyFile)
+			if source == KeySourceFile && err != nil {
+				t.Errorf("the key file was not created: %v", err)
+			}
+			if source == KeySourcePassphrase && !os.IsNotExist(err) {
+				t.Errorf("a key file was created for a passphrase: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "key")); !os.IsNotExist(err) {
+				t.Errorf("the key was written to the data directory: %v", err)
+			}
+
+			reopened, err := Open(dir, WithKeyFile(keyFile))
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			want := []string{"echo Mar 0", "echo Mar 1", "echo Apr 0", "echo Apr 1", "echo May 0", "echo May 1", "echo after encrypt"}
+			if got := storedCommands(t, reopened); !reflect.DeepEqual(got, want) {
+				t.Errorf("decrypted commands = %q, want %q", got, want)
+			}
+			if source == KeySourceFile {
+				if _, err := Open(dir); err == nil {
+					t.Error("Open succeeded without the key file")
+				}
+			}
+
+			if err := reopened.Decrypt(); err != nil {
+				t.Fatalf("Decrypt: %v", err)
+			}
+			if !historyContains(t, dir, "echo Mar 0") || !historyContains(t, dir, "echo after encrypt") {
+				t.Error("decrypted history is not stored in plain text")
+			}
+			plain, err := Open(dir)
+			if err != nil {
+				t.Fatalf("Open after Decrypt: %v", err)
+			}
+			if got := storedCommands(t, plain); !reflect.DeepEqual(got, want) {
+				t.Errorf("commands after Decrypt = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestPassphraseKeyCache パスフレーズから導出した鍵をキャッシュし、
+// RRK_PASSPHRASEのないシェルでもキャッシュがあれば読み書きでき、違うパスフレーズは拒否することを確かめる
+func TestPassphraseKeyCache(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	t.Setenv(PassphraseEnv, "correct horse battery staple")
+
+	dir := t.TempDir()
+	store := openRetentionStore(t, dir)
+	saveAt(t, store, retentionNow, "s", "/work", "echo secret")
+	if err := store.Encrypt(KeySourcePassphrase); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	m, err := store.loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheFile, err := keyCacheFile(m.Encryption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(cacheFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("key cache = %v, %v, want a file only the owner can read", info, err)
+	}
+
+	// キャッシュがあればパスフレーズなしでも記録できる
+	t.Setenv(PassphraseEnv, "")
+	store = openRetentionStore(t, dir)
+	saveAt(t, store, retentionNow, "s", "/work", "echo without passphrase")
+
+	// 違うパスフレーズではキャッシュを使わない
+	t.Setenv(PassphraseEnv, "wrong")
+	if _, err := Open(dir); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Open with a wrong passphrase = %v, want ErrWrongKey", err)
+	}
+
+	// キャッシュがなくパスフレーズもなければ、それとわかるエラーを返す
+	if err := os.Remove(cacheFile); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(PassphraseEnv, "")
+	if _, err := Open(dir); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("Open without a passphrase = %v, want ErrNoPassphrase", err)
+	}
+
+	// 導出し直した鍵を再びキャッシュし、復号するとキャッシュを削除する
+	t.Setenv(PassphraseEnv, "correct horse battery staple")
+	store = openRetentionStore(t, dir)
+	if got := storedCommands(t, store); !reflect.DeepEqual(got, []string{"echo secret", "echo without passphrase"}) {
+		t.Errorf("commands = %q", got)
+	}
+	if _, err := os.Stat(cacheFile); err != nil {
+		t.Errorf("the derived key was not cached again: %v", err)
+	}
+	if err := store.Decrypt(); err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if _, err := os.Stat(cacheFile); !os.IsNotExist(err) {
+		t.Errorf("the key cache was kept after Decrypt: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// IssueKind 履歴の整合性チェックで見つかった問題の種類
+type IssueKind string
+
+const (
+	// IssueInvalidLine JSONとして読めない行（読み込み時は無視される）
+	IssueInvalidLine IssueKind = "invalid-line"
+	// IssueDuplicateID 他のエントリと同じID
+	IssueDuplicateID IssueKind = "duplicate-id"
+	// IssueTimeOrder 直前に記録されたエントリより古い実行時刻
+	IssueTimeOrder IssueKind = "time-order"
+	// IssueEmptyCWD 実行ディレクトリが記録されていない
+	IssueEmptyCWD IssueKind = "empty-cwd"
+	// IssueMissingSegment マニフェストにあるがファイルが見つからないセグメント（読み込み時にエラーになる）
+	IssueMissingSegment IssueKind = "missing-segment"
+)
+
+// Issue 整合性チェックで見つかった問題1件
+type Issue struct {
+	Kind IssueKind
+	// Segment, Line 問題のある行の位置（セグメントのファイル名と1始まりの行番号、セグメント全体の問題では行番号は0）
+	Segment string
+	Line    int
+	// ID 問題のあるエントリのID（無効な行では0）
+	ID int
+	// Detail 問題の内容
+	Detail string
+}
+
+// CheckReport 整合性チェックの結果
+type CheckReport struct {
+	Segments int
+	Entries  int
+	Issues   []Issue
+}
+
+// Count 指定した種類の問題の件数を返す
+func (r *CheckReport) Count(kind IssueKind) int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// RepairResult 修復の結果
+type RepairResult struct {
+	// Quarantined 隔離ファイルに移した無効な行の数
+	Quarantined int
+	// Renumbered 新しいIDを振り直したエントリの数
+	Renumbered int
+	// DroppedSegments ファイルが見つからずマニフェストから外したセグメントの数
+	DroppedSegments int
+}
+
+// quarantinedLine 隔離ファイルに書き出す無効な行
+// 元の内容は文字列のまま残す（UTF-8として不正な場合はBase64で残す）
+type quarantinedLine struct {
+	Segment       string    `json:"segment"`
+	Line          int       `json:"line"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+	Data          string    `json:"data,omitempty"`
+	DataBase64    []byte    `json:"data_base64,omitempty"`
+}
+
+// QuarantineFile 修復時に無効な行を移す隔離ファイルのパスを返す
+func (s *Storage) QuarantineFile() string {
+	return filepath.Join(s.basePath, "quarantine.jsonl")
+}
+
+// segmentMissing エントリがあるはずのセグメントのファイルが、圧縮前後どちらの形式でも見つからないかを返す
+// まだ何も記録していない新しいセグメントはファイルがなくても正常
+func (s *Storage) segmentMissing(seg *segment) bool {
+	if seg.Count == 0 {
+		return false
+	}
+	for _, compressed := range []bool{seg.Compressed, !seg.Compressed} {
+		_, err := os.Stat(s.segmentPath(&segment{Name: seg.Name, Compressed: compressed}))
+		if !errors.Is(err, os.ErrNotExist) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check 全てのセグメントを古い順に読み、無効な行・重複したID・時刻の逆転・空の実行ディレクトリ・見つからないファイルを報告する
+// インポートしたエントリは元の実行時刻を保つため、時刻の逆転は記録されたエントリの間でのみ調べる
+func (s *Storage) Check(ctx context.Context) (*CheckReport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, err := s.manifestForRead()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CheckReport{Segments: len(m.Segments)}
+	// seen 各IDが最初に現れた位置
+	seen := make(map[int]string)
+	var last time.Time
+	for _, seg := range m.Segments {
+		if s.segmentMissing(seg) {
+			report.Issues = append(report.Issues, Issue{
+				Kind:    IssueMissingSegment,
+				Segment: seg.Name,
+				Detail:  fmt.Sprintf("listed in the manifest with %d commands, but the file is missing", seg.Count),
+			})
+			continue
+		}
+		reader, _, err := s.openSegment(seg)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// 記録が始まる前のセグメント
+				continue
+			}
+			return nil, err
+		}
+
+		err = forEachNumberedLine(reader, func(line []byte, number int) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			issue := Issue{Segment: seg.Name, Line: number}
+
+			entry, err := s.decodeEntry(line)
+			if err != nil {
+				issue.Kind, issue.Detail = IssueInvalidLine, err.Error()
+				report.Issues = append(report.Issues, issue)
+				return nil
+			}
+			report.Entries++
+			issue.ID = entry.ID
+
+			location := fmt.Sprintf("%s:%d", seg.Name, number)
+			if first, ok := seen[entry.ID]; ok {
+				issue.Kind, issue.Detail = IssueDuplicateID, "also used at "+first
+				report.Issues = append(report.Issues, issue)
+			} else {
+				seen[entry.ID] = location
+			}
+
+			if entry.ImportKey == "" {
+				if entry.Timestamp.Before(last) {
+					issue.Kind = IssueTimeOrder
+					issue.Detail = fmt.Sprintf("%s is before the previous entry (%s)",
+						entry.Timestamp.Format(time.RFC3339), last.Format(time.RFC3339))
+					report.Issues = append(report.Issues, issue)
+				} else {
+					last = entry.Timestamp
+				}
+			}
+
+			if entry.CWD == "" {
+				issue.Kind, issue.Detail = IssueEmptyCWD, "no working directory recorded"
+				report.Issues = append(report.Issues, issue)
+			}
+			return nil
+		})
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check history segment %s: %w", seg.Name, err)
+		}
+	}
+
+	return report, nil
+}
+
+// Repair 見つからないセグメントをマニフェストから外し、無効な行を隔離ファイルに移し、重複したIDの2件目以降に新しいIDを振り直す
+// 隔離ファイルへの追記はセグメントの書き換えより先に行うため、途中で中断しても行は失われない
+func (s *Storage) Repair(ctx context.Context) (*RepairResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	m, err := s.manifestForWrite()
+	if err != nil {
+		return nil, err
+	}
+	if s.chaining(m) {
+		return nil, ErrAuditMode
+	}
+
+	result := &RepairResult{}
+
+	// ファイルが見つからないセグメントを外す（失われたIDを再利用しないよう最大のIDは残しておく）
+	maxID, duplicates := 0, 0
+	var present []*segment
+	for _, seg := range m.Segments {
+		if s.segmentMissing(seg) {
+			maxID = max(maxID, seg.MaxID)
+			result.DroppedSegments++
+			continue
+		}
+		present = append(present, seg)
+	}
+	m.Segments = present
+
+	// 振り直すIDが既存のIDと重ならないよう、先に最大のIDと重複の件数を調べる
+	seen := make(map[int]bool)
+	for _, seg := range m.Segments {
+		err := s.iterateSegment(seg, false, func(line []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			entry, err := s.decodeEntry(line)
+			if err != nil {
+				return nil
+			}
+			if seen[entry.ID] {
+				duplicates++
+			}
+			seen[entry.ID] = true
+			maxID = max(maxID, entry.ID)
+			return nil
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	nextID, err := s.readCounter(m)
+	if err != nil {
+		return nil, err
+	}
+	nextID = max(nextID, maxID+1)
+	if duplicates > 0 || result.DroppedSegments > 0 {
+		// allocateIDsと同様、書き換えより先にカウンタを進めておく
+		counter := strconv.Itoa(nextID + duplicates)
+		if err := os.WriteFile(s.counterFile(), []byte(counter), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write ID counter: %w", err)
+		}
+	}
+
+	// 隔離ファイルは無効な行が見つかった時に開く
+	var quarantine *os.File
+	defer func() {
+		if quarantine != nil {
+			quarantine.Close()
+		}
+	}()
+
+	now := s.now()
+	clear(seen)
+	renumber := func(entry *history.Entry) bool {
+		if seen[entry.ID] {
+			entry.ID = nextID
+			nextID++
+			result.Renumbered++
+		}
+		seen[entry.ID] = true
+		return true
+	}
+	isolate := func(seg *segment, line []byte, number int) (bool, error) {
+		if quarantine == nil {
+			file, err := os.OpenFile(s.QuarantineFile(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				return false, fmt.Errorf("failed to open quarantine file: %w", err)
+			}
+			quarantine = file
+		}
+
+		record := quarantinedLine{Segment: seg.Name, Line: number, QuarantinedAt: now}
+		if utf8.Valid(line) {
+			record.Data = string(line)
+		} else {
+			record.DataBase64 = line
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return false, err
+		}
+		if _, err := quarantine.Write(append(data, '\n')); err != nil {
+			return false, fmt.Errorf("failed to write quarantine file: %w", err)
+		}
+		if err := quarantine.Sync(); err != nil {
+			return false, fmt.Errorf("failed to write quarantine file: %w", err)
+		}
+		result.Quarantined++
+		return false, nil
+	}
+
+	// 古いセグメントから順に書き換えるため、最初に現れたエントリが元のIDを保つ
+	err = s.rewriteSegments(m, renumber, isolate)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
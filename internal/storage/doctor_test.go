@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// TestRepairDropsMissingSegments マニフェストにあるセグメントのファイルが消えていても
+// Checkが報告し、Repairがマニフェストから外して、失われたIDを再利用しないことを確かめる
+func TestRepairDropsMissingSegments(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC)
+	store, err := Open(dir, WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	save := func(command string) {
+		t.Helper()
+		if err := store.Save(&history.Entry{SessionID: "doctor", CWD: "/tmp", Command: command, Timestamp: now}); err != nil {
+			t.Fatalf("Save(%q): %v", command, err)
+		}
+	}
+
+	save("echo april 1")
+	save("echo april 2")
+	now = now.AddDate(0, 1, 0)
+	save("echo may")
+
+	// 4月のセグメントは月が変わった時点で圧縮されている
+	if err := os.Remove(filepath.Join(dir, "history", "2024-04.jsonl.gz")); err != nil {
+		t.Fatalf("removing the sealed segment: %v", err)
+	}
+	if err := os.Remove(store.counterFile()); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := store.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if got := report.Count(IssueMissingSegment); got != 1 || report.Issues[0].Segment != "2024-04.jsonl" {
+		t.Fatalf("Check issues = %+v, want the missing 2024-04.jsonl", report.Issues)
+	}
+
+	result, err := store.Repair(context.Background())
+	if err != nil {
+		t.Fatalf("Repair: %v", err)
+	}
+	if result.DroppedSegments != 1 {
+		t.Errorf("DroppedSegments = %d, want 1", result.DroppedSegments)
+	}
+
+	report, err = store.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check after repair: %v", err)
+	}
+	if len(report.Issues) != 0 || report.Entries != 1 {
+		t.Errorf("Check after repair = %+v, want 1 command and no issues", report)
+	}
+
+	// 外したセグメントのIDは振り直さない
+	save("echo after repair")
+	entries, err := store.Load(history.EntryFilter{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	ids := map[int]bool{}
+	for _, entry := range entries {
+		ids[entry.ID] = true
+	}
+	if len(entries) != 2 || !ids[3] || !ids[4] {
+		t.Errorf("entries after repair = %+v, want IDs 3 and 4", entries)
+	}
+}
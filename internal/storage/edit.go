@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// Delete 指定したIDのエントリを削除し、削除した件数を返す
+// 封印済みのセグメントも含め、変更のあったセグメントだけを一時ファイル経由でアトミックに書き換える
+func (s *Storage) Delete(ids ...int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	targets := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		targets[id] = true
+	}
+
+	deleted := 0
+	err := s.Rewrite(func(entry *history.Entry) bool {
+		if targets[entry.ID] {
+			deleted++
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// Replace 同じIDのエントリを置き換える（見つからなければエラー）
+func (s *Storage) Replace(entry history.Entry) error {
+	found := false
+	err := s.Rewrite(func(current *history.Entry) bool {
+		if current.ID == entry.ID {
+			*current = entry
+			found = true
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %d", ErrNotFound, entry.ID)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// openSealedStore 3月・4月の封印済みセグメントと5月の書き込み中のセグメントにそれぞれ2件ずつ記録したStorageを開く
+// IDは3月が1・2、4月が3・4、5月が5・6
+func openSealedStore(t *testing.T, dir string, opts ...Option) *Storage {
+	t.Helper()
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	store, err := Open(dir, append([]Option{WithClock(func() time.Time { return now })}, opts...)...)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for month := 0; month < 3; month++ {
+		for i := 0; i < 2; i++ {
+			saveAt(t, store, now, "s", "/work", fmt.Sprintf("echo %s %d", now.Format("Jan"), i))
+		}
+		now = now.AddDate(0, 1, 0)
+	}
+	now = now.AddDate(0, -1, 0)
+	return store
+}
+
+// readSealed 封印済みセグメントを展開して内容を返す（gzipでなければテストを失敗させる）
+func readSealed(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("%s is not gzip compressed: %v", path, err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestDeleteAcrossSegments 封印済みセグメントと書き込み中のセグメントにまたがるIDを削除し、
+// 封印済みセグメントは圧縮されたまま書き換えられ、件数がマニフェストに反映されることを確かめる
+func TestDeleteAcrossSegments(t *testing.T) {
+	dir := t.TempDir()
+	store := openSealedStore(t, dir)
+
+	deleted, err := store.Delete(1, 3, 4, 6, 99)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if deleted != 4 {
+		t.Errorf("Delete = %d, want 4", deleted)
+	}
+	if ids := storedIDs(t, store); !reflect.DeepEqual(ids, []int{2, 5}) {
+		t.Errorf("entries left = %v, want [2 5]", ids)
+	}
+
+	if march := readSealed(t, filepath.Join(dir, "history", "2024-03.jsonl.gz")); strings.Contains(march, "echo Mar 0") || !strings.Contains(march, "echo Mar 1") {
+		t.Errorf("March segment = %q, want only the second entry", march)
+	}
+	m, err := store.loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, seg := range m.Segments {
+		counts[seg.Name] = seg.Count
+		if seg.Name != "2024-05.jsonl" && !seg.Compressed {
+			t.Errorf("segment %s is no longer sealed", seg.Name)
+		}
+	}
+	// 空になった4月のセグメントは残っていても0件として扱う
+	if counts["2024-03.jsonl"] != 1 || counts["2024-04.jsonl"] != 0 || counts["2024-05.jsonl"] != 1 {
+		t.Errorf("segment counts = %v, want March 1, April 0 and May 1", counts)
+	}
+
+	// 削除した後も新しいエントリには続きのIDを振る
+	if id := saveAt(t, store, time.Date(2024, 5, 11, 12, 0, 0, 0, time.Local), "s", "/work", "echo next"); id != 7 {
+		t.Errorf("next ID = %d, want 7", id)
+	}
+}
+
+// TestReplaceSealedEntry 封印済みセグメントのエントリを置き換えても、圧縮されたまま内容が更新されることを確かめる
+func TestReplaceSealedEntry(t *testing.T) {
+	dir := t.TempDir()
+	store := openSealedStore(t, dir)
+
+	entry, err := store.GetByID(3)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	entry.Command = "echo replaced"
+	if err := store.Replace(*entry); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	got, err := store.GetByID(3)
+	if err != nil || got.Command != "echo replaced" {
+		t.Errorf("GetByID(3) = %+v, %v, want the replaced command", got, err)
+	}
+	april := readSealed(t, filepath.Join(dir, "history", "2024-04.jsonl.gz"))
+	if !strings.Contains(april, "echo replaced") || strings.Contains(april, "echo Apr 0") {
+		t.Errorf("April segment = %q, want the replaced command only", april)
+	}
+	if ids := storedIDs(t, store); !reflect.DeepEqual(ids, []int{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("entries = %v, want [1 2 3 4 5 6]", ids)
+	}
+}
+
+// TestReplaceMissingID 存在しないIDを置き換えようとするとErrNotFoundを返し、ファイルを変更しないことを確かめる
+func TestReplaceMissingID(t *testing.T) {
+	dir := t.TempDir()
+	store := openSealedStore(t, dir)
+	before := dirSnapshot(t, dir)
+
+	err := store.Replace(history.Entry{ID: 42, SessionID: "s", CWD: "/work", Command: "echo missing"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Replace = %v, want ErrNotFound", err)
+	}
+	if after := dirSnapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Error("replacing a missing ID changed the history files")
+	}
+}
+
+// TestForgetPreviewAndConfirm rrk forget と同じ手順で、一覧表示したIDだけが削除されることを確かめる
+// 確認を待つ間に記録されたコマンドは一致しても削除せず、取り消した場合はファイルを変更しない
+func TestForgetPreviewAndConfirm(t *testing.T) {
+	dir := t.TempDir()
+	store := openSealedStore(t, dir)
+	secret := regexp.MustCompile(`mysql .*-p\S+`)
+	at := time.Date(2024, 5, 12, 12, 0, 0, 0, time.Local)
+	saveAt(t, store, at, "s", "/work", "mysql -u root -phunter2") // 7
+	saveAt(t, store, at, "s", "/work", "mysql -u root -p")        // 8
+
+	preview := func(filter history.EntryFilter) []int {
+		t.Helper()
+		var ids []int
+		err := store.Iterate(context.Background(), filter, func(entry history.Entry) error {
+			ids = append(ids, entry.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Iterate: %v", err)
+		}
+		return ids
+	}
+
+	// 一覧表示（--dry-run）と確認の取り消しではファイルを変更しない
+	before := dirSnapshot(t, dir)
+	if ids := preview(history.EntryFilter{CommandRegex: secret}); !reflect.DeepEqual(ids, []int{7}) {
+		t.Errorf("regex preview = %v, want [7]", ids)
+	}
+	if after := dirSnapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Error("previewing changed the history files")
+	}
+
+	// 封印済みセグメントにも一致するエントリがある
+	ids := preview(history.EntryFilter{CommandContains: "echo Mar"})
+	if !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Fatalf("preview = %v, want [1 2]", ids)
+	}
+	// 確認を待つ間に一致するコマンドが記録される
+	late := saveAt(t, store, at, "s", "/work", "echo Mar again")
+
+	deleted, err := store.Delete(ids...)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Delete = %d, want 2", deleted)
+	}
+	if left := preview(history.EntryFilter{CommandContains: "echo Mar"}); !reflect.DeepEqual(left, []int{late}) {
+		t.Errorf("matching entries left = %v, want only the one recorded after the preview (%d)", left, late)
+	}
+}
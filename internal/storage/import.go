@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// Import 他のシェルの履歴から取り込んだエントリを、それぞれの実行時刻の月のセグメントに保存する
+// 保持ポリシーが古い月のセグメントを削除できるよう、過去の月のエントリは現在のセグメントに混ぜない
+// 既存のセグメントには実行時刻の順になるよう挿入し、新しい順に読む際に取り込んだ古いエントリを最新として扱わないようにする
+// 監査モードではチェーンの順序を保つため、SaveAllと同じく全て現在のセグメントに追記する
+func (s *Storage) Import(entries []*history.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := s.manifestForWrite()
+	if err != nil {
+		return err
+	}
+	if s.chaining(m) {
+		return s.saveEntries(m, entries)
+	}
+
+	if err := s.allocateIDs(m, entries); err != nil {
+		return err
+	}
+	active, err := s.activeSegment(m, s.now())
+	if err != nil {
+		return err
+	}
+
+	// 現在のセグメントの月以降のエントリは現在のセグメントに、それより前は月ごとにまとめる
+	var current []*history.Entry
+	past := make(map[string][]*history.Entry)
+	for _, entry := range entries {
+		month := entry.Timestamp.Local().Format("2006-01")
+		if month >= active.Month {
+			current = append(current, entry)
+			continue
+		}
+		past[month] = append(past[month], entry)
+	}
+
+	if len(current) > 0 {
+		if err := s.mergeEntries(active, current); err != nil {
+			return err
+		}
+	}
+
+	months := make([]string, 0, len(past))
+	for month := range past {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	for _, month := range months {
+		if err := s.mergeEntries(monthSegment(m, month), past[month]); err != nil {
+			return err
+		}
+	}
+
+	return s.saveManifest(m)
+}
+
+// monthSegment 過去の月のエントリを加えるセグメントを返す
+// その月のセグメントがなければ、封印済みのセグメントを月の順になる位置に追加する
+func monthSegment(m *manifest, month string) *segment {
+	for i := len(m.Segments) - 1; i >= 0; i-- {
+		if m.Segments[i].Month == month {
+			return m.Segments[i]
+		}
+	}
+
+	seg := &segment{Name: uniqueSegmentName(m, month), Month: month, Compressed: true}
+	i := sort.Search(len(m.Segments), func(i int) bool {
+		return m.Segments[i].Month > month
+	})
+	m.Segments = append(m.Segments[:i], append([]*segment{seg}, m.Segments[i:]...)...)
+	return seg
+}
+
+// mergeEntries 実行時刻の順になるよう、セグメントの既存のエントリの間にエントリを挿入し、一時ファイル経由で書き換える
+// 実行時刻が同じエントリは既存のエントリの後に置き、時刻を読めない行はその位置のまま残す
+// 書き込み中のセグメントで、全てが既存のエントリ以降なら書き換えずに追記する
+func (s *Storage) mergeEntries(seg *segment, entries []*history.Entry) error {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	if !seg.Compressed && (seg.Count == 0 || !entries[0].Timestamp.Before(seg.To)) {
+		return s.appendEntries(seg, entries)
+	}
+
+	var buf bytes.Buffer
+	next := 0
+	// writeBefore before（nilなら末尾）より前に実行したエントリを書き込む
+	writeBefore := func(before *time.Time) error {
+		for ; next < len(entries) && (before == nil || entries[next].Timestamp.Before(*before)); next++ {
+			data, err := s.encodeEntry(entries[next])
+			if err != nil {
+				return fmt.Errorf("failed to encode entry: %w", err)
+			}
+			buf.Write(data)
+		}
+		return nil
+	}
+
+	reader, _, err := s.openSegment(seg)
+	switch {
+	case err == nil:
+		err = forEachNumberedLine(reader, func(line []byte, _ int) error {
+			if entry, _, _, err := s.decodeLine(line); err == nil {
+				if err := writeBefore(&entry.Timestamp); err != nil {
+					return err
+				}
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+			return nil
+		})
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read history segment %s: %w", seg.Name, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	if err := writeBefore(nil); err != nil {
+		return err
+	}
+
+	err = writeAtomic(s.segmentPath(seg), func(w io.Writer) error {
+		if !seg.Compressed {
+			_, err := buf.WriteTo(w)
+			return err
+		}
+		gz := gzip.NewWriter(w)
+		if _, err := buf.WriteTo(gz); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write history segment %s: %w", seg.Name, err)
+	}
+
+	for _, entry := range entries {
+		seg.add(entry)
+	}
+	return nil
+}
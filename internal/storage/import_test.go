@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// TestImportFilesEntriesByMonth 取り込んだエントリが現在のセグメントではなく、
+// 実行時刻の月のセグメントに保存されることを確かめる
+func TestImportFilesEntriesByMonth(t *testing.T) {
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	store, err := Open(t.TempDir(), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveAt := func(at time.Time, command string) {
+		t.Helper()
+		if err := store.Save(&history.Entry{SessionID: "s", CWD: "/tmp", Command: command, Timestamp: at}); err != nil {
+			t.Fatalf("Save(%q): %v", command, err)
+		}
+	}
+
+	// 3月と5月に記録し、3月のセグメントを封印しておく
+	now = time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	saveAt(now, "echo march")
+	now = time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	saveAt(now, "echo may")
+
+	imported := []*history.Entry{
+		{Command: "old january", Timestamp: time.Date(2024, 1, 5, 9, 0, 0, 0, time.Local)},
+		{Command: "old march", Timestamp: time.Date(2024, 3, 20, 9, 0, 0, 0, time.Local)},
+		{Command: "this month", Timestamp: time.Date(2024, 5, 1, 9, 0, 0, 0, time.Local)},
+	}
+	for _, entry := range imported {
+		entry.SessionID, entry.CWD = "imported", "/tmp"
+	}
+	if err := store.Import(imported); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	m, err := store.loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	counts := make(map[string]int)
+	for _, seg := range m.Segments {
+		got = append(got, seg.Month)
+		counts[seg.Month] = seg.Count
+	}
+	want := []string{"2024-01", "2024-03", "2024-05"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("segment months = %v, want %v", got, want)
+	}
+	if counts["2024-01"] != 1 || counts["2024-03"] != 2 || counts["2024-05"] != 2 {
+		t.Errorf("segment counts = %v, want 2024-01:1 2024-03:2 2024-05:2", counts)
+	}
+
+	report, err := store.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if report.Entries != 5 || report.Count(IssueInvalidLine) != 0 || report.Count(IssueDuplicateID) != 0 {
+		t.Errorf("Check = %+v, want 5 valid entries", report)
+	}
+
+	// 月単位の期間指定で、取り込んだエントリがその月から読める
+	entries, err := store.Load(history.EntryFilter{
+		Since: time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local),
+		Until: time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local),
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("March entries = %d, want 2", len(entries))
+	}
+}
+
+// TestImportMergesByTime 既存の月のセグメントに取り込んだエントリが実行時刻の順に挿入され、
+// 新しい順に読んでも件数で保持しても、取り込んだ古いエントリを最新として扱わないことを確かめる
+func TestImportMergesByTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	store, err := Open(t.TempDir(), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	save := func(at time.Time, command string) {
+		t.Helper()
+		if err := store.Save(&history.Entry{SessionID: "s", CWD: "/tmp", Command: command, Timestamp: at}); err != nil {
+			t.Fatalf("Save(%q): %v", command, err)
+		}
+	}
+
+	// 3月のセグメントを封印し、5月のセグメントに書き込み中
+	save(now, "march 10")
+	now = time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	save(now, "may 15")
+
+	imported := []*history.Entry{
+		{Command: "march 20", Timestamp: time.Date(2024, 3, 20, 9, 0, 0, 0, time.Local)},
+		{Command: "may 1", Timestamp: time.Date(2024, 5, 1, 9, 0, 0, 0, time.Local)},
+		{Command: "march 5", Timestamp: time.Date(2024, 3, 5, 9, 0, 0, 0, time.Local)},
+	}
+	for _, entry := range imported {
+		entry.SessionID, entry.CWD = "imported", "/tmp"
+	}
+	if err := store.Import(imported); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	var newest []string
+	err = store.IterateReverse(context.Background(), history.EntryFilter{}, func(entry history.Entry) error {
+		newest = append(newest, entry.Command)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("IterateReverse: %v", err)
+	}
+	want := []string{"may 15", "may 1", "march 20", "march 10", "march 5"}
+	if !reflect.DeepEqual(newest, want) {
+		t.Errorf("newest first = %q, want %q", newest, want)
+	}
+
+	// 件数で保持すると、取り込んだエントリではなく最後に記録したエントリが残る
+	if _, err := store.Prune(context.Background(), history.EntryFilter{}, Retention{MaxEntries: 1}, false, nil); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	entries, err := store.Load(history.EntryFilter{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Command != "may 15" {
+		t.Errorf("entries after keeping the last one = %+v, want only %q", entries, "may 15")
+	}
+}
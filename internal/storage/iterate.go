@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// ErrStop Iterateのコールバックが返すと、走査をエラーなしで終了する
+var ErrStop = errors.New("stop iteration")
+
+// Iterate フィルタ条件に一致するエントリを古い順（filter.Newestなら新しい順）にfnへ渡す
+// 全件をメモリに読み込まないため、大きな履歴でも使用メモリは一定に保たれる
+// filter.Offset件を読み飛ばした後、fnがErrStopを返すか、filter.Limit件を渡した時点で終了してnilを返す
+// fnの中から同じStorageに書き込んではいけない（読み込みロックを保持しているため）
+func (s *Storage) Iterate(ctx context.Context, filter history.EntryFilter, fn func(history.Entry) error) error {
+	return s.iterate(ctx, filter, filter.Newest, fn)
+}
+
+// IterateReverse Iterateと同様だが、ファイルの末尾から読み、常に新しい順にfnへ渡す
+// filter.Limitは最新のLimit件を意味する
+func (s *Storage) IterateReverse(ctx context.Context, filter history.EntryFilter, fn func(history.Entry) error) error {
+	return s.iterate(ctx, filter, true, fn)
+}
+
+// iterate IterateとIterateReverseの共通処理
+// 時間で絞り込む場合、期間外のセグメントは開かずに読み飛ばす
+func (s *Storage) iterate(ctx context.Context, filter history.EntryFilter, reverse bool, fn func(history.Entry) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, err := s.manifestForRead()
+	if err != nil {
+		return err
+	}
+
+	skipped, count := 0, 0
+	visit := func(line []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		entry, err := s.decodeEntry(line)
+		if err != nil {
+			return nil // 無効なエントリをスキップ
+		}
+		if !s.matchesFilter(&entry, filter) {
+			return nil
+		}
+		if skipped < filter.Offset {
+			skipped++
+			return nil
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+
+		// 制限を適用
+		count++
+		if filter.Limit > 0 && count >= filter.Limit {
+			return ErrStop
+		}
+		return nil
+	}
+
+	segments := m.Segments
+	if reverse {
+		segments = make([]*segment, len(m.Segments))
+		for i, seg := range m.Segments {
+			segments[len(segments)-1-i] = seg
+		}
+	}
+
+	for _, seg := range segments {
+		if !seg.overlaps(filter.Since, filter.Until) {
+			continue
+		}
+		err := s.iterateSegment(seg, reverse, visit)
+		if err == ErrStop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iterateSegment セグメントの各行をvisitに渡す（ErrStopはそのまま返す）
+func (s *Storage) iterateSegment(seg *segment, reverse bool, visit func(line []byte) error) error {
+	reader, file, err := s.openSegment(seg)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// forEachLineはErrStopをnilに変換するため、中断したことを記録して呼び出し元に伝える
+	stop := false
+	wrapped := func(line []byte) error {
+		err := visit(line)
+		if err == ErrStop {
+			stop = true
+		}
+		return err
+	}
+
+	switch {
+	case !reverse:
+		err = forEachLine(reader, wrapped)
+	case file != nil:
+		err = forEachLineReverse(file, wrapped)
+	default:
+		// 圧縮済みのセグメントは末尾から読めないため、展開して逆順に渡す
+		var lines [][]byte
+		err = forEachLine(reader, func(line []byte) error {
+			lines = append(lines, append([]byte(nil), line...))
+			return nil
+		})
+		for i := len(lines) - 1; err == nil && i >= 0; i-- {
+			err = wrapped(lines[i])
+		}
+		if err == ErrStop {
+			err = nil
+		}
+	}
+
+	if err == nil && stop {
+		return ErrStop
+	}
+	return err
+}
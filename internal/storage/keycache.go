@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MRyutaro/rrk/internal/paths"
+)
+
+// パスフレーズからの鍵の導出（PBKDF2）はフックがコマンドを記録するたびに行うと遅いため、
+// 一度導出した鍵をログイン中だけ残るランタイムディレクトリにキャッシュする
+// キャッシュには鍵と、鍵をHMACの鍵にしたパスフレーズのMACを保存し、RRK_PASSPHRASEが変わっていないかを確かめる
+
+// keyCacheFile 暗号化の設定（ソルトと反復回数）に対応する鍵のキャッシュファイルのパスを返す
+func keyCacheFile(enc *encryption) (string, error) {
+	dir, err := paths.RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("key-%x-%d", enc.Salt, enc.Iterations)), nil
+}
+
+// passphraseMAC キャッシュした鍵がパスフレーズから導出したものかを確かめるためのMAC
+func passphraseMAC(key []byte, passphrase string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(passphrase))
+	return mac.Sum(nil)
+}
+
+// cachedPassphraseKey キャッシュした鍵を返す（なければnil）
+// RRK_PASSPHRASEが設定されていれば、そのパスフレーズから導出した鍵の場合だけ返す
+// 設定されていなければ、同じログイン中に他のシェルで導出した鍵を使う（エクスポートし忘れたシェルでも記録を続けられる）
+func cachedPassphraseKey(enc *encryption, passphrase string) []byte {
+	path, err := keyCacheFile(enc)
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) != keySize+sha256.Size {
+		return nil
+	}
+	key, mac := data[:keySize], data[keySize:]
+	if passphrase != "" && !hmac.Equal(mac, passphraseMAC(key, passphrase)) {
+		return nil
+	}
+	return key
+}
+
+// cachePassphraseKey 正しいことを確かめた鍵をキャッシュする（キャッシュできなくても記録は続ける）
+func (s *Storage) cachePassphraseKey(enc *encryption, key []byte, passphrase string) {
+	// 読み込み専用ではファイルを作成しない
+	if s.readOnly || passphrase == "" {
+		return
+	}
+	path, err := keyCacheFile(enc)
+	if err != nil {
+		return
+	}
+	writeFileAtomic(path, append(append([]byte(nil), key...), passphraseMAC(key, passphrase)...))
+}
+
+// removeKeyCache 復号した履歴の鍵のキャッシュを削除する
+func removeKeyCache(enc *encryption) {
+	if enc.Source != KeySourcePassphrase {
+		return
+	}
+	if path, err := keyCacheFile(enc); err == nil {
+		os.Remove(path)
+	}
+}
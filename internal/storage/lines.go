@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// reverseChunkSize ファイル末尾から読む際に一度に読み込むバイト数
+const reverseChunkSize = 64 * 1024
+
+// forEachLine 長さに制限なく1行ずつfnに渡す（改行は除き、空行は渡さない）
+// bufio.Scannerと違い、巨大なコマンドを含む行でも読み込みが失敗しない
+// fnがErrStopを返すと走査を終えてnilを返し、それ以外のエラーはそのまま返す
+func forEachLine(r io.Reader, fn func(line []byte) error) error {
+	return forEachNumberedLine(r, func(line []byte, _ int) error {
+		return fn(line)
+	})
+}
+
+// forEachNumberedLine forEachLineと同様だが、ファイル内の行番号（1始まり、空行も数える）も渡す
+func forEachNumberedLine(r io.Reader, fn func(line []byte, number int) error) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for number := 1; ; number++ {
+		line, err := reader.ReadBytes('\n')
+		visit := func(line []byte) error { return fn(line, number) }
+		if ferr := visitLine(line, visit); ferr != nil {
+			return stopped(ferr)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read history file: %w", err)
+		}
+	}
+}
+
+// forEachLineReverse ファイルの末尾から1行ずつfnに渡す（新しい行から順に）
+// ファイル全体を読み込まずに、末尾から一定サイズずつ読み進める
+func forEachLineReverse(file *os.File, fn func(line []byte) error) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	buf := make([]byte, reverseChunkSize)
+	// carry 前のチャンクに続く、まだ行頭が見つかっていない部分
+	var carry []byte
+	for pos := stat.Size(); pos > 0; {
+		n := int64(len(buf))
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		if _, err := file.ReadAt(buf[:n], pos); err != nil {
+			return fmt.Errorf("failed to read history file: %w", err)
+		}
+
+		block := append(buf[:n:n], carry...)
+		for {
+			i := bytes.LastIndexByte(block, '\n')
+			if i < 0 {
+				break
+			}
+			if err := visitLine(block[i+1:], fn); err != nil {
+				return stopped(err)
+			}
+			block = block[:i]
+		}
+		// bufは次の読み込みで上書きされるためコピーしておく
+		carry = append([]byte(nil), block...)
+	}
+
+	return stopped(visitLine(carry, fn))
+}
+
+// visitLine 改行を除いた行をfnに渡す（空行は無視）
+func visitLine(line []byte, fn func(line []byte) error) error {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return nil
+	}
+	return fn(line)
+}
+
+// stopped ErrStopによる中断を正常終了として扱う
+func stopped(err error) error {
+	if err == ErrStop {
+		return nil
+	}
+	return err
+}
+
+// decodeEntry JSON行（暗号化されていれば復号して）を履歴エントリに変換
+func (s *Storage) decodeEntry(line []byte) (history.Entry, error) {
+	entry, _, _, err := s.decodeLine(line)
+	return entry, err
+}
+
+// decodeLine decodeEntryと同様だが、復号した平文のJSONと、行が暗号化されていたかも返す
+func (s *Storage) decodeLine(line []byte) (entry history.Entry, plain []byte, encrypted bool, err error) {
+	plain, encrypted, err = s.openLine(line)
+	if err != nil {
+		return entry, nil, encrypted, err
+	}
+	err = json.Unmarshal(plain, &entry)
+	return entry, plain, encrypted, err
+}
+
+// encodeEntry 履歴エントリを改行付きのJSON行に変換（暗号化が有効なら暗号化する）
+func (s *Storage) encodeEntry(entry *history.Entry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = s.sealLine(data); err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
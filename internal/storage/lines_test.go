@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// TestLongLines 数MBのコマンドを含む履歴を古い順・新しい順のどちらでも読めることを確かめる
+func TestLongLines(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// reverseChunkSizeの境界をまたぐよう、大きな行と小さな行を交互に並べる
+	sizes := []int{10, 3 << 20, 20, 5<<20 + 123, 30}
+	for i, size := range sizes {
+		entry := &history.Entry{SessionID: "long", CWD: "/tmp", Command: fmt.Sprintf("%d:%s", i, strings.Repeat("x", size))}
+		if err := store.Save(entry); err != nil {
+			t.Fatalf("Save(%d bytes): %v", size, err)
+		}
+	}
+
+	check := func(name string, entries []history.Entry, order []int) {
+		t.Helper()
+		if len(entries) != len(order) {
+			t.Fatalf("%s: read %d entries, want %d", name, len(entries), len(order))
+		}
+		for i, index := range order {
+			want := fmt.Sprintf("%d:%s", index, strings.Repeat("x", sizes[index]))
+			if entries[i].Command != want || entries[i].ID != index+1 {
+				t.Errorf("%s: entry %d is ID %d with %d bytes, want ID %d with %d bytes",
+					name, i, entries[i].ID, len(entries[i].Command), index+1, len(want))
+			}
+		}
+	}
+
+	collect := func(iterate func(context.Context, history.EntryFilter, func(history.Entry) error) error, filter history.EntryFilter) []history.Entry {
+		var entries []history.Entry
+		err := iterate(context.Background(), filter, func(entry history.Entry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("iterate: %v", err)
+		}
+		return entries
+	}
+
+	check("forward", collect(store.Iterate, history.EntryFilter{}), []int{0, 1, 2, 3, 4})
+	check("reverse", collect(store.IterateReverse, history.EntryFilter{}), []int{4, 3, 2, 1, 0})
+	check("reverse limit", collect(store.IterateReverse, history.EntryFilter{Offset: 1, Limit: 2}), []int{3, 2})
+}
+
+// TestLongLegacyLine 旧形式の履歴に含まれる数MBの行を移行でき、次のIDがその行から決まることを確かめる
+func TestLongLegacyLine(t *testing.T) {
+	dir := t.TempDir()
+	big := strings.Repeat("x", 3<<20)
+	line := fmt.Sprintf(`{"id":1,"session_id":"long","cwd":"/tmp/big","command":"echo %s","timestamp":"2024-01-01T00:00:00Z"}`+"\n", big)
+	if err := os.WriteFile(filepath.Join(dir, "history.jsonl"), []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	small := &history.Entry{SessionID: "long", CWD: "/tmp/small", Command: "echo small"}
+	if err := store.Save(small); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if small.ID != 2 {
+		t.Errorf("new entry got ID %d, want 2", small.ID)
+	}
+
+	entry, err := store.GetByID(1)
+	if err != nil {
+		t.Fatalf("GetByID(1): %v", err)
+	}
+	if entry.Command != "echo "+big {
+		t.Errorf("migrated command has %d bytes, want %d", len(entry.Command), len(big)+5)
+	}
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// Retention 履歴を保持する範囲（ゼロ値は無制限）
+type Retention struct {
+	// MaxAge これより前に実行されたエントリを削除
+	MaxAge time.Duration
+	// MaxEntries 新しい順にこの件数を超えたエントリを削除
+	MaxEntries int
+}
+
+// IsZero 保持の制限がないかを返す
+func (r Retention) IsZero() bool {
+	return r.MaxAge <= 0 && r.MaxEntries <= 0
+}
+
+// SetRetention 月が変わりセグメントを圧縮する際に自動で適用する保持ポリシーを設定
+func (s *Storage) SetRetention(retention Retention) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = retention
+}
+
+// Prune scopeに一致するエントリのうち、policyの範囲外のものを削除して件数を返す
+// policyがゼロ値ならscopeに一致する全てのエントリを削除する
+// 削除するエントリはvisitに新しい順で渡され、dryRunなら実際には削除しない
+// 削除は変更のあったセグメントを一時ファイルに書いてからリネームするため、途中で中断しても履歴全体が失われることはない
+func (s *Storage) Prune(ctx context.Context, scope history.EntryFilter, policy Retention, dryRun bool, visit func(history.Entry)) (int, error) {
+	now := s.now()
+	var cutoff time.Time
+	if policy.MaxAge > 0 {
+		cutoff = now.Add(-policy.MaxAge)
+	}
+
+	scope.Limit, scope.Offset, scope.Newest = 0, 0, false
+	// 件数の制限がなければ、期間外のセグメントを読み飛ばせる
+	search := scope
+	if policy.MaxEntries <= 0 && !cutoff.IsZero() {
+		if search.Until.IsZero() || cutoff.Before(search.Until) {
+			search.Until = cutoff
+		}
+	}
+
+	// 新しい順に数えながら、削除するエントリを決める
+	victims := make(map[int]bool)
+	position := 0
+	err := s.IterateReverse(ctx, search, func(entry history.Entry) error {
+		position++
+		expired := policy.IsZero() ||
+			(policy.MaxEntries > 0 && position > policy.MaxEntries) ||
+			(!cutoff.IsZero() && entry.Timestamp.Before(cutoff))
+		if !expired {
+			return nil
+		}
+		victims[entry.ID] = true
+		if visit != nil {
+			visit(entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if dryRun || len(victims) == 0 {
+		return len(victims), nil
+	}
+
+	err = s.Rewrite(func(entry *history.Entry) bool {
+		// IDが重複している古い履歴のため、scopeにも一致するかを確かめる
+		return !(victims[entry.ID] && s.matchesFilter(entry, scope))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(victims), nil
+}
+
+// applyRetention 保持ポリシーの範囲外のエントリを削除する（ロック取得中に呼ぶ）
+// 全体が範囲外の封印済みセグメントはファイルごと削除し、一部だけが範囲外のセグメントは書き換える
+func (s *Storage) applyRetention(m *manifest, now time.Time) error {
+	// 監査モードではハッシュチェーンを保つため自動では削除しない
+	retention := s.retention
+	if retention.IsZero() || s.chaining(m) {
+		return nil
+	}
+
+	var cutoff time.Time
+	if retention.MaxAge > 0 {
+		cutoff = now.Add(-retention.MaxAge)
+	}
+
+	// 古いセグメントから順に、件数の上限を超えている分を削除
+	excess := 0
+	if retention.MaxEntries > 0 {
+		total := 0
+		for _, seg := range m.Segments {
+			total += seg.Count
+		}
+		if total > retention.MaxEntries {
+			excess = total - retention.MaxEntries
+		}
+	}
+
+	var kept, removed []*segment
+	for i, seg := range m.Segments {
+		active := i == len(m.Segments)-1
+		wholeSegment := (excess > 0 && seg.Count <= excess) || (!cutoff.IsZero() && seg.Count > 0 && seg.To.Before(cutoff))
+		if wholeSegment && !active {
+			excess -= min(seg.Count, excess)
+			removed = append(removed, seg)
+			continue
+		}
+
+		if excess > 0 || (!cutoff.IsZero() && seg.Count > 0 && seg.From.Before(cutoff)) {
+			_, err := s.rewriteSegment(seg, func(entry *history.Entry) bool {
+				if excess > 0 {
+					excess--
+					return false
+				}
+				return cutoff.IsZero() || !entry.Timestamp.Before(cutoff)
+			}, nil)
+			if err != nil {
+				return err
+			}
+		}
+		kept = append(kept, seg)
+	}
+	m.Segments = kept
+
+	if err := s.saveManifest(m); err != nil {
+		return err
+	}
+	return s.removeSegments(removed)
+}
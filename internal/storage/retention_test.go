@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// retentionNow 保持ポリシーのテストで使う現在時刻
+var retentionNow = time.Date(2024, 5, 20, 12, 0, 0, 0, time.Local)
+
+// openRetentionStore 現在時刻を固定したStorageを開く
+func openRetentionStore(t *testing.T, dir string, opts ...Option) *Storage {
+	t.Helper()
+	opts = append([]Option{WithClock(func() time.Time { return retentionNow })}, opts...)
+	store, err := Open(dir, opts...)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return store
+}
+
+// saveAt 指定した時刻に実行したエントリを保存し、IDを返す
+func saveAt(t *testing.T, store *Storage, at time.Time, sessionID, cwd, command string) int {
+	t.Helper()
+	entry := &history.Entry{SessionID: sessionID, CWD: cwd, Command: command, Timestamp: at}
+	if err := store.Save(entry); err != nil {
+		t.Fatalf("Save(%q): %v", command, err)
+	}
+	return entry.ID
+}
+
+// storedIDs 保存されているエントリのIDを古い順に返す
+func storedIDs(t *testing.T, store *Storage) []int {
+	t.Helper()
+	ids := []int{}
+	err := store.Iterate(context.Background(), history.EntryFilter{}, func(entry history.Entry) error {
+		ids = append(ids, entry.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate: %v", err)
+	}
+	return ids
+}
+
+// dirSnapshot ディレクトリ内の全てのファイルの内容を返す
+func dirSnapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[path] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestPruneAgeAndCount --older-than と --keep-last を同時に指定すると、どちらかの範囲外のエントリを削除することを確かめる
+func TestPruneAgeAndCount(t *testing.T) {
+	store := openRetentionStore(t, t.TempDir())
+	for _, days := range []int{10, 8, 5, 3, 1} {
+		saveAt(t, store, retentionNow.AddDate(0, 0, -days), "s", "/work", fmt.Sprintf("echo %dd", days))
+	}
+
+	var visited []int
+	count, err := store.Prune(context.Background(), history.EntryFilter{}, Retention{MaxAge: 7 * 24 * time.Hour, MaxEntries: 2}, false,
+		func(entry history.Entry) { visited = append(visited, entry.ID) })
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	// 1・2は7日より前、3は新しい方から3件目
+	if count != 3 || !reflect.DeepEqual(visited, []int{3, 2, 1}) {
+		t.Errorf("Prune = %d, visited %v, want 3 entries visited newest first", count, visited)
+	}
+	if ids := storedIDs(t, store); !reflect.DeepEqual(ids, []int{4, 5}) {
+		t.Errorf("entries left = %v, want [4 5]", ids)
+	}
+}
+
+// TestPruneScope --dir と --session に一致するエントリだけを削除することを確かめる
+func TestPruneScope(t *testing.T) {
+	store := openRetentionStore(t, t.TempDir())
+	at := retentionNow.Add(-time.Hour)
+	saveAt(t, store, at, "s1", "/work/a", "make")           // 1
+	saveAt(t, store, at, "s1", "/work/a/sub", "make")       // 2
+	saveAt(t, store, at, "s1", "/work/b", "make")           // 3
+	saveAt(t, store, at, "s2", "/work/ab", "make")          // 4
+	saveAt(t, store, at, "s2", "/work/b", "make test")      // 5
+	saveAt(t, store, at, "s2", "/work/b", "make install")   // 6
+	saveAt(t, store, at, "s1", "/home", "cd /work/a && ls") // 7
+
+	// ディレクトリだけを指定すると、その下で実行したエントリ（先頭のcdで移動した先を含み、/work/ab は含まない）を全て削除する
+	dir := "/work/a"
+	count, err := store.Prune(context.Background(), history.EntryFilter{CWDPrefix: &dir}, Retention{}, false, nil)
+	if err != nil {
+		t.Fatalf("Prune(dir): %v", err)
+	}
+	if ids := storedIDs(t, store); count != 3 || !reflect.DeepEqual(ids, []int{3, 4, 5, 6}) {
+		t.Errorf("after pruning %s: count %d, entries left %v, want 3 and [3 4 5 6]", dir, count, ids)
+	}
+
+	// セッションを指定すると、件数の制限もそのセッションの中で数える
+	session := "s2"
+	count, err = store.Prune(context.Background(), history.EntryFilter{SessionID: &session}, Retention{MaxEntries: 1}, false, nil)
+	if err != nil {
+		t.Fatalf("Prune(session): %v", err)
+	}
+	if ids := storedIDs(t, store); count != 2 || !reflect.DeepEqual(ids, []int{3, 6}) {
+		t.Errorf("after pruning %s: count %d, entries left %v, want 2 and [3 6]", session, count, ids)
+	}
+}
+
+// TestPruneDryRun ドライランでは削除対象を報告するだけで、ファイルを変更しないことを確かめる
+func TestPruneDryRun(t *testing.T) {
+	dir := t.TempDir()
+	store := openRetentionStore(t, dir)
+	for _, days := range []int{40, 30, 1} {
+		saveAt(t, store, retentionNow.AddDate(0, 0, -days), "s", "/work", fmt.Sprintf("echo %dd", days))
+	}
+	before := dirSnapshot(t, dir)
+
+	var visited []int
+	count, err := store.Prune(context.Background(), history.EntryFilter{}, Retention{MaxAge: 7 * 24 * time.Hour}, true,
+		func(entry history.Entry) { visited = append(visited, entry.ID) })
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if count != 2 || !reflect.DeepEqual(visited, []int{2, 1}) {
+		t.Errorf("dry run = %d, visited %v, want 2 entries", count, visited)
+	}
+	if after := dirSnapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Error("dry run changed the history files")
+	}
+}
+
+// TestRetentionOnRotation 月が変わりセグメントを封印する際に保持ポリシーが適用され、
+// 全体が範囲外の封印済みセグメントはファイルごと削除されることを確かめる
+func TestRetentionOnRotation(t *testing.T) {
+	dir := t.TempDir()
+	april := time.Date(2024, 4, 10, 12, 0, 0, 0, time.Local)
+	now := april
+	clock := WithClock(func() time.Time { return now })
+
+	store, err := Open(dir, clock)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		saveAt(t, store, now, "s", "/work", fmt.Sprintf("echo april %d", i))
+	}
+	now = now.AddDate(0, 1, 0)
+	for i := 0; i < 3; i++ {
+		saveAt(t, store, now, "s", "/work", fmt.Sprintf("echo may %d", i))
+	}
+
+	// 6月の最初の記録で5月のセグメントを封印し、新しい方から2件だけ残す
+	now = now.AddDate(0, 1, 0)
+	store, err = Open(dir, clock, WithRetention(Retention{MaxEntries: 2}))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveAt(t, store, now, "s", "/work", "echo june")
+
+	if ids := storedIDs(t, store); !reflect.DeepEqual(ids, []int{5, 6, 7}) {
+		t.Errorf("entries left = %v, want [5 6 7]", ids)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "history", "2024-04.jsonl.gz")); !os.IsNotExist(err) {
+		t.Errorf("the expired April segment was not removed: %v", err)
+	}
+	m, err := store.loadManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, seg := range m.Segments {
+		names = append(names, seg.Name)
+		if seg.Name == "2024-05.jsonl" && (!seg.Compressed || seg.Count != 2) {
+			t.Errorf("May segment = %+v, want a sealed segment with 2 entries", seg)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"2024-05.jsonl", "2024-06.jsonl"}) {
+		t.Errorf("segments = %v, want May and June", names)
+	}
+
+	// 期間による保持では、全体が期間外の封印済みセグメントを削除する
+	now = now.AddDate(0, 1, 0)
+	store, err = Open(dir, clock, WithRetention(Retention{MaxAge: 20 * 24 * time.Hour}))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	saveAt(t, store, now, "s", "/work", "echo july")
+	if ids := storedIDs(t, store); !reflect.DeepEqual(ids, []int{8}) {
+		t.Errorf("entries left = %v, want [8]", ids)
+	}
+	for _, name := range []string{"2024-05.jsonl.gz", "2024-06.jsonl.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, "history", name)); !os.IsNotExist(err) {
+			t.Errorf("the expired segment %s was not removed: %v", name, err)
+		}
+	}
+}
+
+// TestRewriteKeepsOriginalWhenRenameFails 書き換えたファイルのリネームに失敗しても、元の履歴が残ることを確かめる
+func TestRewriteKeepsOriginalWhenRenameFails(t *testing.T) {
+	dir := t.TempDir()
+	store := openRetentionStore(t, dir)
+	for _, days := range []int{3, 2, 1} {
+		saveAt(t, store, retentionNow.AddDate(0, 0, -days), "s", "/work", fmt.Sprintf("echo %dd", days))
+	}
+	before := dirSnapshot(t, dir)
+
+	errRename := errors.New("rename failed")
+	renameFile = func(string, string) error { return errRename }
+	t.Cleanup(func() { renameFile = os.Rename })
+
+	if _, err := store.Prune(context.Background(), history.EntryFilter{}, Retention{MaxEntries: 1}, false, nil); !errors.Is(err, errRename) {
+		t.Fatalf("Prune = %v, want the rename error", err)
+	}
+	// 一時ファイルも残らない
+	if after := dirSnapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Errorf("a failed rewrite changed the history files: %v", after)
+	}
+
+	renameFile = os.Rename
+	if ids := storedIDs(t, store); !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Errorf("entries left = %v, want [1 2 3]", ids)
+	}
+}
@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// manifestVersion マニフェストの形式のバージョン
+const manifestVersion = 1
+
+// manifest 履歴セグメントの一覧（history/manifest.json）
+// セグメントは作成順に並び、最後の非圧縮セグメントに追記する
+type manifest struct {
+	Version  int        `json:"version"`
+	Segments []*segment `json:"segments"`
+	// Encryption 履歴を暗号化している場合の設定
+	Encryption *encryption `json:"encryption,omitempty"`
+	// Audit 監査モードで最後に記録したエントリ
+	Audit *auditAnchor `json:"audit,omitempty"`
+}
+
+// segment 記録した月ごとに分割された履歴ファイル1つ分の情報
+type segment struct {
+	// Name ファイル名（圧縮済みの場合は ".gz" を付けたファイルに保存される）
+	Name string `json:"name"`
+	// Month このセグメントに記録を始めた月（"2006-01"）
+	Month string `json:"month"`
+	// From, To 含まれるエントリの最も古い・新しい実行時刻（時間で絞り込む際の読み飛ばしに使う）
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Count エントリ数、MaxID 含まれる最大のID
+	Count int `json:"count"`
+	MaxID int `json:"max_id"`
+	// Compressed 封印されgzip圧縮済みか
+	Compressed bool `json:"compressed,omitempty"`
+}
+
+// add エントリの追加に合わせてセグメントの情報を更新
+func (seg *segment) add(entry *history.Entry) {
+	if seg.Count == 0 || entry.Timestamp.Before(seg.From) {
+		seg.From = entry.Timestamp
+	}
+	if seg.Count == 0 || entry.Timestamp.After(seg.To) {
+		seg.To = entry.Timestamp
+	}
+	if entry.ID > seg.MaxID {
+		seg.MaxID = entry.ID
+	}
+	seg.Count++
+}
+
+// reset 書き換え前に集計をやり直すため情報を初期化
+func (seg *segment) reset() {
+	seg.From, seg.To = time.Time{}, time.Time{}
+	seg.Count, seg.MaxID = 0, 0
+}
+
+// overlaps [since, until) の期間に含まれるエントリがありうるかを返す（ゼロ値は無制限）
+func (seg *segment) overlaps(since, until time.Time) bool {
+	if seg.Count == 0 {
+		return false
+	}
+	if !since.IsZero() && seg.To.Before(since) {
+		return false
+	}
+	if !until.IsZero() && !seg.From.Before(until) {
+		return false
+	}
+	return true
+}
+
+// segmentDir セグメントを保存するディレクトリを返す
+func (s *Storage) segmentDir() string {
+	return filepath.Join(s.basePath, "history")
+}
+
+// manifestFile マニフェストのパスを返す
+func (s *Storage) manifestFile() string {
+	return filepath.Join(s.segmentDir(), "manifest.json")
+}
+
+// legacyHistoryFile セグメント導入前の単一の履歴ファイルのパスを返す
+func (s *Storage) legacyHistoryFile() string {
+	return filepath.Join(s.basePath, "history.jsonl")
+}
+
+// segmentPath セグメントのファイルパスを返す
+func (s *Storage) segmentPath(seg *segment) string {
+	name := seg.Name
+	if seg.Compressed {
+		name += ".gz"
+	}
+	return filepath.Join(s.segmentDir(), name)
+}
+
+// loadManifest マニフェストを読み込む（まだなければ空のマニフェストを返す）
+func (s *Storage) loadManifest() (*manifest, error) {
+	data, err := os.ReadFile(s.manifestFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &manifest{Version: manifestVersion}, nil
+		}
+		return nil, fmt.Errorf("failed to read history manifest: %w", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid history manifest %s: %w", s.manifestFile(), err)
+	}
+	return &m, nil
+}
+
+// saveManifest マニフェストを一時ファイル経由でアトミックに書き込む（ロック取得中に呼ぶ）
+func (s *Storage) saveManifest(m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode history manifest: %w", err)
+	}
+	return writeFileAtomic(s.manifestFile(), append(data, '\n'))
+}
+
+// openSegment セグメントを読み込み用に開く（圧縮済みなら透過的に展開する）
+// 他のプロセスが圧縮した直後でも読めるよう、見つからなければもう一方の形式を試す
+func (s *Storage) openSegment(seg *segment) (io.ReadCloser, *os.File, error) {
+	compressed := seg.Compressed
+	file, err := os.Open(s.segmentPath(seg))
+	if os.IsNotExist(err) {
+		compressed = !compressed
+		file, err = os.Open(s.segmentPath(&segment{Name: seg.Name, Compressed: compressed}))
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open history segment %s: %w", seg.Name, err)
+	}
+
+	if !compressed {
+		return file, file, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to open history segment %s: %w", seg.Name, err)
+	}
+	return &gzipFile{Reader: reader, file: file}, nil, nil
+}
+
+// gzipFile 展開しながら読むファイル（Closeで元のファイルも閉じる）
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// activeSegment 追記先のセグメントを返す（ロック取得中に呼ぶ）
+// 月が変わっていれば新しいセグメントを作り、それより前のセグメントを圧縮して封印する（コンパクション）
+func (s *Storage) activeSegment(m *manifest, now time.Time) (*segment, error) {
+	month := now.Local().Format("2006-01")
+
+	if n := len(m.Segments); n > 0 {
+		last := m.Segments[n-1]
+		// 時計が戻った場合も含め、同じ月の間は同じセグメントに追記する
+		if !last.Compressed && month <= last.Month {
+			return last, nil
+		}
+	}
+
+	seg := &segment{Name: uniqueSegmentName(m, month), Month: month}
+	m.Segments = append(m.Segments, seg)
+	if err := s.saveManifest(m); err != nil {
+		return nil, err
+	}
+
+	if err := s.compact(m, now); err != nil {
+		return nil, err
+	}
+	return seg, nil
+}
+
+// compact 古いセグメントを圧縮し、保持ポリシーを適用する（ロック取得中に呼ぶ）
+func (s *Storage) compact(m *manifest, now time.Time) error {
+	if err := s.rotate(m); err != nil {
+		return err
+	}
+	return s.applyRetention(m, now)
+}
+
+// uniqueSegmentName 既存のセグメントと重ならないファイル名を返す
+func uniqueSegmentName(m *manifest, month string) string {
+	used := make(map[string]bool, len(m.Segments))
+	for _, seg := range m.Segments {
+		used[seg.Name] = true
+	}
+
+	name := month + ".jsonl"
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s-%d.jsonl", month, i)
+	}
+	return name
+}
+
+// rotate 最後のセグメント以外の非圧縮セグメントを圧縮して封印する（ロック取得中に呼ぶ）
+func (s *Storage) rotate(m *manifest) error {
+	for i, seg := range m.Segments {
+		if i == len(m.Segments)-1 || seg.Compressed {
+			continue
+		}
+		if err := s.sealSegment(m, seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealSegment セグメントをgzip圧縮し、マニフェストを更新してから元のファイルを削除する
+func (s *Storage) sealSegment(m *manifest, seg *segment) error {
+	plainPath := s.segmentPath(seg)
+	source, err := os.Open(plainPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// 中断された圧縮の続き（圧縮済みファイルだけが残っている）
+			seg.Compressed = true
+			return s.saveManifest(m)
+		}
+		return fmt.Errorf("failed to open history segment %s: %w", seg.Name, err)
+	}
+	defer source.Close()
+
+	err = writeAtomic(s.segmentPath(&segment{Name: seg.Name, Compressed: true}), func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if _, err := io.Copy(gz, source); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compress history segment %s: %w", seg.Name, err)
+	}
+
+	seg.Compressed = true
+	if err := s.saveManifest(m); err != nil {
+		return err
+	}
+
+	source.Close()
+	if err := os.Remove(plainPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove compressed history segment: %w", err)
+	}
+	return nil
+}
+
+// migrateLegacy 単一ファイル形式の history.jsonl をセグメントに移行する（ロック取得中に呼ぶ）
+// マニフェストがなければ実行時刻の月ごとに分割し、すでにあれば最新のセグメントに追記する
+// 全て書き込めた後で元のファイルを削除する
+func (s *Storage) migrateLegacy(m *manifest, now time.Time) error {
+	legacy, err := os.Open(s.legacyHistoryFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer legacy.Close()
+
+	// 月ごとの書き込み先（マニフェストがある場合は追記先のセグメントのみ）
+	writers := make(map[*segment]*segmentWriter)
+	defer func() {
+		for _, w := range writers {
+			w.file.Close()
+		}
+	}()
+
+	var fixed *segment
+	if len(m.Segments) > 0 {
+		if fixed, err = s.activeSegment(m, now); err != nil {
+			return err
+		}
+	}
+	byMonth := make(map[string]*segment)
+	current := fixed
+
+	err = forEachLine(legacy, func(line []byte) error {
+		entry, decodeErr := s.decodeEntry(line)
+		if fixed == nil && decodeErr == nil {
+			month := entry.Timestamp.Local().Format("2006-01")
+			if byMonth[month] == nil {
+				byMonth[month] = &segment{Name: month + ".jsonl", Month: month}
+			}
+			current = byMonth[month]
+		}
+		if current == nil {
+			// 解析できない行は直前のエントリと同じセグメントに残す（先頭なら現在の月）
+			month := now.Local().Format("2006-01")
+			if byMonth[month] == nil {
+				byMonth[month] = &segment{Name: month + ".jsonl", Month: month}
+			}
+			current = byMonth[month]
+		}
+
+		w := writers[current]
+		if w == nil {
+			flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+			if current == fixed {
+				flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+			}
+			if err := os.MkdirAll(s.segmentDir(), 0700); err != nil {
+				return fmt.Errorf("failed to create history directory: %w", err)
+			}
+			file, err := os.OpenFile(s.segmentPath(current), flag, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create history segment: %w", err)
+			}
+			w = &segmentWriter{file: file, writer: bufio.NewWriter(file)}
+			writers[current] = w
+		}
+
+		w.writer.Write(line)
+		w.writer.WriteByte('\n')
+		if decodeErr == nil {
+			current.add(&entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, w := range writers {
+		if err := w.flush(); err != nil {
+			return fmt.Errorf("failed to write history segment: %w", err)
+		}
+	}
+
+	if fixed == nil {
+		months := make([]string, 0, len(byMonth))
+		for month := range byMonth {
+			months = append(months, month)
+		}
+		sort.Strings(months)
+		for _, month := range months {
+			m.Segments = append(m.Segments, byMonth[month])
+		}
+	}
+	if err := s.saveManifest(m); err != nil {
+		return err
+	}
+
+	// 移行が完了したので元のファイルを削除し、古い月を圧縮する
+	legacy.Close()
+	if err := os.Remove(s.legacyHistoryFile()); err != nil {
+		return fmt.Errorf("failed to remove migrated history file: %w", err)
+	}
+	return s.rotate(m)
+}
+
+// segmentWriter 移行時に使う書き込み中のセグメント
+type segmentWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// flush バッファを書き出してディスクに同期
+func (w *segmentWriter) flush() error {
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// writeFileAtomic 一時ファイルに書いてからリネームし、途中の状態が読まれないようにする
+func writeFileAtomic(path string, data []byte) error {
+	return writeAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// renameFile 一時ファイルを置き換える関数（リネームに失敗した場合のテストで差し替える）
+var renameFile = os.Rename
+
+// writeAtomic writeで書いた内容を一時ファイル経由でpathに置く
+func writeAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	// 成功時はリネーム済みなので削除は失敗するだけ
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := tmp.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+	if err := write(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := renameFile(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
@@ -2,255 +2,713 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/MRyutaro/rrk/internal/filelock"
 	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/paths"
 )
 
 // Storage 履歴エントリの永続化ストレージを管理
+// 書き込みはプロセス内ではmuで、プロセス間ではロックファイルで排他制御する
 type Storage struct {
-	basePath string
-	mu       sync.RWMutex
-	nextID   int
+	basePath  string
+	mu        sync.RWMutex
+	retention Retention
+	// clock 現在時刻を返す関数
+	clock func() time.Time
+	// cipher 暗号化された履歴の鍵（暗号化していなければnil）、cipherCheck 読み込んだ鍵の確認用データ
+	cipher      *Cipher
+	cipherCheck []byte
+	// keyFile 鍵ファイルのパス（空ならデータディレクトリの key）
+	keyFile string
+	// plaintext 復号中は鍵があっても平文で書き込む
+	plaintext bool
+	// audit 監査モード（ハッシュチェーンを付けて保存し、書き換えを拒否する）
+	audit bool
+	// homeDir ディレクトリで絞り込む際に先頭の "cd ~" を解決するためのホームディレクトリ
+	homeDir string
+	// cache 保存に使う状態をメモリに保持する場合に設定（WithWriteCache）
+	cache *writeCache
+	// readOnly OpenReadOnlyで開いた（ロックを取得する書き込みを拒否する）
+	readOnly bool
 }
 
-// New 新しいStorageインスタンスを作成
+// Option Openで作成するStorageの設定
+type Option func(*Storage)
+
+// WithClock 現在時刻の代わりに使う関数を設定する（記録する月や保持ポリシーの基準になる）
+func WithClock(now func() time.Time) Option {
+	return func(s *Storage) {
+		s.clock = now
+	}
+}
+
+// WithRetention 月が変わりセグメントを圧縮する際に自動で適用する保持ポリシーを設定する
+func WithRetention(retention Retention) Option {
+	return func(s *Storage) {
+		s.retention = retention
+	}
+}
+
+// WithAudit 監査モードを設定する
+func WithAudit(enabled bool) Option {
+	return func(s *Storage) {
+		s.audit = enabled
+	}
+}
+
+// New 使用中のプロファイルのデータディレクトリでStorageを作成
 func New() (*Storage, error) {
-	homeDir, err := os.UserHomeDir()
+	basePath, err := paths.DataDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return nil, err
 	}
+	return Open(basePath)
+}
 
-	basePath := filepath.Join(homeDir, ".rrk")
-	if err := os.MkdirAll(basePath, 0755); err != nil {
+// Open 指定したディレクトリに履歴を保存するStorageを作成（ディレクトリがなければ作成する）
+// 単一ファイル形式（history.jsonl）の履歴が残っていれば、セグメント形式に移行する
+func Open(basePath string, opts ...Option) (*Storage, error) {
+	if basePath == "" {
+		return nil, errors.New("storage path is empty")
+	}
+	if err := os.MkdirAll(basePath, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create rrk directory: %w", err)
 	}
 
-	s := &Storage{
-		basePath: basePath,
-		nextID:   1,
+	s := &Storage{basePath: basePath, clock: time.Now}
+	s.homeDir, _ = os.UserHomeDir()
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err := os.MkdirAll(s.segmentDir(), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	if _, err := os.Stat(s.legacyHistoryFile()); err == nil {
+		if err := s.migrate(); err != nil {
+			return nil, fmt.Errorf("failed to migrate history: %w", err)
+		}
 	}
 
-	// 既存エントリを読み込んで次のIDを決定
-	if err := s.loadNextID(); err != nil {
+	// 暗号化されていれば鍵を読み込む
+	if _, err := s.manifestForWrite(); err != nil {
 		return nil, err
 	}
 
 	return s, nil
 }
 
-// historyFile 履歴ファイルのパスを返す
-func (s *Storage) historyFile() string {
-	return filepath.Join(s.basePath, "history.jsonl")
+// OpenReadOnly 既存の履歴を読み込み専用で開く
+// Openと異なり、ディレクトリの作成・旧形式の移行・鍵ファイルの作成を行わず、書き込み用のロックも取得しない
+// 履歴を変更するメソッドはエラーを返す
+func OpenReadOnly(basePath string, opts ...Option) (*Storage, error) {
+	if basePath == "" {
+		return nil, errors.New("storage path is empty")
+	}
+	info, err := os.Stat(basePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rrk history: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("failed to open rrk history: %s is not a directory", basePath)
+	}
+
+	s := &Storage{basePath: basePath, clock: time.Now, readOnly: true}
+	s.homeDir, _ = os.UserHomeDir()
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// 移行はロックを取って書き込むため、読み込み専用では行わない
+	if _, err := os.Stat(s.legacyHistoryFile()); err == nil {
+		return nil, fmt.Errorf("history in %s uses the old single-file format; run any rrk command once to migrate it", basePath)
+	}
+
+	// 暗号化されていれば鍵を読み込む
+	m, err := s.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.syncEncryption(m); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path 履歴を保存しているディレクトリを返す
+func (s *Storage) Path() string {
+	return s.basePath
 }
 
-// loadNextID 既存エントリから次の使用可能IDを読み込み
-func (s *Storage) loadNextID() error {
-	file, err := os.Open(s.historyFile())
+// now 現在時刻を返す（WithClockで差し替えられる）
+func (s *Storage) now() time.Time {
+	return s.clock()
+}
+
+// migrate ロックを取得して旧形式の履歴を移行
+func (s *Storage) migrate() error {
+	unlock, err := s.lock()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // まだ履歴ファイルがない
-		}
-		return fmt.Errorf("failed to open history file: %w", err)
+		return err
 	}
-	defer file.Close()
+	defer unlock()
+
+	m, err := s.loadManifest()
+	if err != nil {
+		return err
+	}
+	return s.migrateLegacy(m, s.now())
+}
+
+// lockPath プロセス間ロック用ファイルのパスを返す
+func (s *Storage) lockPath() string {
+	return filepath.Join(s.basePath, "lock")
+}
+
+// counterFile 次に割り当てるIDを保存するファイルのパスを返す
+func (s *Storage) counterFile() string {
+	return filepath.Join(s.basePath, "next_id")
+}
+
+// ErrReadOnly 読み込み専用で開いた履歴は変更できない
+var ErrReadOnly = errors.New("history was opened read-only")
+
+// lock 他のrrkプロセスとの間で排他ロックを取得し、解放する関数を返す
+// 書き込みは全てこのロックを取得するため、読み込み専用ならここで拒否する
+func (s *Storage) lock() (func(), error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	return filelock.Lock(s.lockPath())
+}
 
-	scanner := bufio.NewScanner(file)
-	var maxID int
+// allocateIDs IDのないエントリに連番を割り当て、カウンタを永続化する（ロック取得中に呼ぶ）
+func (s *Storage) allocateIDs(m *manifest, entries []*history.Entry) error {
+	nextID, err := s.readCounter(m)
+	if err != nil {
+		return err
+	}
+	_, err = s.assignIDs(nextID, entries)
+	return err
+}
 
-	for scanner.Scan() {
-		var entry history.Entry
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
-			continue // 無効なエントリをスキップ
+// assignIDs IDのないエントリにnextIDから連番を割り当ててカウンタを永続化し、次に割り当てるIDを返す（ロック取得中に呼ぶ）
+// 追記より先にカウンタを書き込むため、途中で失敗してもIDが重複することはない（欠番にはなる）
+func (s *Storage) assignIDs(nextID int, entries []*history.Entry) (int, error) {
+	assigned := false
+	for _, entry := range entries {
+		if entry.ID == 0 {
+			entry.ID = nextID
+			nextID++
+			assigned = true
+		} else if entry.ID >= nextID {
+			nextID = entry.ID + 1
+			assigned = true
 		}
-		if entry.ID > maxID {
-			maxID = entry.ID
+	}
+	if !assigned {
+		return nextID, nil
+	}
+
+	if err := os.WriteFile(s.counterFile(), []byte(strconv.Itoa(nextID)), 0600); err != nil {
+		return 0, fmt.Errorf("failed to write ID counter: %w", err)
+	}
+	return nextID, nil
+}
+
+// readCounter 保存されたカウンタを読み込む（ない・壊れている場合はマニフェストの最大IDから求める）
+func (s *Storage) readCounter(m *manifest) (int, error) {
+	data, err := os.ReadFile(s.counterFile())
+	if err == nil {
+		if nextID, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && nextID > 0 {
+			return nextID, nil
 		}
+	} else if !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read ID counter: %w", err)
 	}
 
-	s.nextID = maxID + 1
-	return scanner.Err()
+	maxID := 0
+	for _, seg := range m.Segments {
+		if seg.MaxID > maxID {
+			maxID = seg.MaxID
+		}
+	}
+	return maxID + 1, nil
 }
 
 // Save 新しい履歴エントリを保存
 func (s *Storage) Save(entry *history.Entry) error {
+	return s.SaveAll([]*history.Entry{entry})
+}
+
+// SaveAll 複数のエントリをまとめて保存
+// 現在の月のセグメントに追記し、月が変わっていれば古いセグメントを圧縮する
+func (s *Storage) SaveAll(entries []*history.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.cache != nil {
+		return s.saveCached(entries)
+	}
+
+	m, err := s.manifestForWrite()
+	if err != nil {
+		return err
+	}
+	return s.saveEntries(m, entries)
+}
+
+// recentRecordLines 同じ記録要求を保存済みか調べる、最後のセグメントの末尾の行数
+const recentRecordLines = 64
+
+// saveEntries エントリにIDを割り当て、現在の月のセグメントに追記する（ロック取得中に呼ぶ）
+func (s *Storage) saveEntries(m *manifest, entries []*history.Entry) error {
+	entries, err := s.skipRecorded(m, entries)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
 	// IDが設定されていない場合は割り当て
-	if entry.ID == 0 {
-		entry.ID = s.nextID
-		s.nextID++
+	if err := s.allocateIDs(m, entries); err != nil {
+		return err
 	}
 
-	// ファイルを追加モードで開く
-	file, err := os.OpenFile(s.historyFile(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	seg, err := s.activeSegment(m, s.now())
 	if err != nil {
-		return fmt.Errorf("failed to open history file: %w", err)
+		return err
 	}
-	defer file.Close()
 
-	// エントリをJSON行として書き込み
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(entry); err != nil {
-		return fmt.Errorf("failed to write entry: %w", err)
+	if s.chaining(m) {
+		if err := s.chainEntries(m, entries); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	if err := s.appendEntries(seg, entries); err != nil {
+		return err
+	}
+	return s.saveManifest(m)
 }
 
-// Load フィルタ条件に基づいて履歴エントリを読み込み
-func (s *Storage) Load(filter history.EntryFilter) ([]history.Entry, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// skipRecorded 同じRecordIDのエントリが既に保存されていれば、そのIDを設定して保存する対象から外す（ロック取得中に呼ぶ）
+// デーモンの応答が届かずにフックが直接書き込んだ場合も、後から書き込む側が重複に気付ける
+func (s *Storage) skipRecorded(m *manifest, entries []*history.Entry) ([]*history.Entry, error) {
+	pending := false
+	for _, entry := range entries {
+		if entry.RecordID != "" {
+			pending = true
+		}
+	}
+	if !pending {
+		return entries, nil
+	}
 
-	file, err := os.Open(s.historyFile())
+	recorded, err := s.recentRecords(m)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []history.Entry{}, nil // まだ履歴がない
-		}
-		return nil, fmt.Errorf("failed to open history file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	return skipRecordIDs(entries, recordIDs(recorded)), nil
+}
 
-	var entries []history.Entry
-	scanner := bufio.NewScanner(file)
+// recordRef 保存済みのエントリのRecordIDとID
+type recordRef struct {
+	recordID string
+	id       int
+}
 
-	for scanner.Scan() {
-		var entry history.Entry
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
-			continue // 無効なエントリをスキップ
-		}
+// recentRecords 最後のセグメントの末尾にあるエントリのRecordIDを古い順に返す
+// 重複は直後に起きるため、末尾のrecentRecordLines行だけを調べる
+func (s *Storage) recentRecords(m *manifest) ([]recordRef, error) {
+	if len(m.Segments) == 0 {
+		return nil, nil
+	}
 
-		// フィルタを適用
-		if filter.SessionID != nil && entry.SessionID != *filter.SessionID {
-			continue
+	var refs []recordRef
+	lines := 0
+	err := s.iterateSegment(m.Segments[len(m.Segments)-1], true, func(line []byte) error {
+		if lines++; lines > recentRecordLines {
+			return ErrStop
 		}
-		if filter.CWD != nil && entry.CWD != *filter.CWD {
-			continue
+		entry, err := s.decodeEntry(line)
+		if err != nil || entry.RecordID == "" {
+			return nil
 		}
+		refs = append(refs, recordRef{recordID: entry.RecordID, id: entry.ID})
+		return nil
+	})
+	if err != nil && err != ErrStop && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	slices.Reverse(refs)
+	return refs, nil
+}
 
-		entries = append(entries, entry)
+// recordIDs RecordIDからIDを引くマップを作成
+func recordIDs(refs []recordRef) map[string]int {
+	ids := make(map[string]int, len(refs))
+	for _, ref := range refs {
+		ids[ref.recordID] = ref.id
+	}
+	return ids
+}
 
-		// 制限を適用
-		if filter.Limit > 0 && len(entries) >= filter.Limit {
-			break
+// skipRecordIDs recordedに含まれるRecordIDのエントリにそのIDを設定し、残りのエントリを返す
+func skipRecordIDs(entries []*history.Entry, recorded map[string]int) []*history.Entry {
+	var unsaved []*history.Entry
+	for _, entry := range entries {
+		if id, ok := recorded[entry.RecordID]; ok && entry.RecordID != "" {
+			entry.ID = id
+			continue
 		}
+		unsaved = append(unsaved, entry)
 	}
+	return unsaved
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read history file: %w", err)
+// appendEntries 非圧縮のセグメントにエントリを追記し、セグメントの情報を更新する
+func (s *Storage) appendEntries(seg *segment, entries []*history.Entry) error {
+	if len(entries) == 0 {
+		return nil
 	}
 
-	return entries, nil
+	// ファイルを追加モードで開く
+	file, err := os.OpenFile(s.segmentPath(seg), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer file.Close()
+
+	// エントリをJSON行として書き込み
+	writer := bufio.NewWriter(file)
+	for _, entry := range entries {
+		data, err := s.encodeEntry(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode entry: %w", err)
+		}
+		writer.Write(data)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write entries: %w", err)
+	}
+
+	for _, entry := range entries {
+		seg.add(entry)
+	}
+	return nil
 }
 
-// GetByID IDにより特定の履歴エントリを取得
-func (s *Storage) GetByID(id int) (*history.Entry, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// Rewrite 全エントリにfnを適用し、変更のあったセグメントを一時ファイル経由でアトミックに書き換える
+// fnがfalseを返したエントリは削除され、解析できない行はそのまま残す
+// 圧縮済みのセグメントは圧縮したまま書き換え、空になった封印済みセグメントは削除する
+// 監査モードではErrAuditModeを返す
+func (s *Storage) Rewrite(fn func(entry *history.Entry) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	file, err := os.Open(s.historyFile())
+	// 書き換え中に他プロセスが追記した行が失われないようにロックする
+	unlock, err := s.lock()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("history entry not found")
-		}
-		return nil, fmt.Errorf("failed to open history file: %w", err)
+		return err
 	}
-	defer file.Close()
+	defer unlock()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var entry history.Entry
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
-			continue
+	m, err := s.manifestForWrite()
+	if err != nil {
+		return err
+	}
+	// 設定で監査モードを無効にしても、チェーンが始まっていれば書き換えない
+	if s.chaining(m) {
+		return ErrAuditMode
+	}
+	return s.rewriteSegments(m, fn, nil)
+}
+
+// rewriteSegments 全てのセグメントを書き換え、空になった封印済みセグメントを削除する（ロック取得中に呼ぶ）
+func (s *Storage) rewriteSegments(m *manifest, fn func(entry *history.Entry) bool, invalid invalidLineFunc) error {
+	var kept, removed []*segment
+	for i, seg := range m.Segments {
+		empty, err := s.rewriteSegment(seg, fn, invalid)
+		if err != nil {
+			return err
 		}
-		if entry.ID == id {
-			return &entry, nil
+		if empty && seg.Compressed && i < len(m.Segments)-1 {
+			removed = append(removed, seg)
+			continue
 		}
+		kept = append(kept, seg)
 	}
+	m.Segments = kept
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read history file: %w", err)
+	if err := s.saveManifest(m); err != nil {
+		return err
 	}
+	return s.removeSegments(removed)
+}
 
-	return nil, fmt.Errorf("history entry not found")
+// removeSegments マニフェストから外したセグメントのファイルを削除する
+// 先にマニフェストを保存してから呼ぶため、途中で中断してもマニフェストに存在しないファイルが残るだけで済む
+func (s *Storage) removeSegments(segs []*segment) error {
+	for _, seg := range segs {
+		if err := os.Remove(s.segmentPath(seg)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove history segment %s: %w", seg.Name, err)
+		}
+	}
+	return nil
 }
 
-// ListSessions 全ての一意なセッションIDを返す
-func (s *Storage) ListSessions() ([]string, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// invalidLineFunc 書き換え中に見つかったJSONとして読めない行を受け取り、残すかを返す
+type invalidLineFunc func(seg *segment, line []byte, number int) (keep bool, err error)
 
-	file, err := os.Open(s.historyFile())
+// rewriteSegment 1つのセグメントにfnを適用し、変更があれば書き換える
+// 無効な行はinvalidがfalseを返した場合のみ取り除く（invalidがnilならそのまま残す）
+// セグメントに何も残らなかった場合はemptyがtrueになる
+func (s *Storage) rewriteSegment(seg *segment, fn func(entry *history.Entry) bool, invalid invalidLineFunc) (empty bool, err error) {
+	reader, _, err := s.openSegment(seg)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
+		if errors.Is(err, os.ErrNotExist) {
+			seg.reset()
+			return true, nil
 		}
-		return nil, fmt.Errorf("failed to open history file: %w", err)
+		return false, err
 	}
-	defer file.Close()
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	changed, lines := false, 0
+	seg.reset()
+	err = forEachNumberedLine(reader, func(line []byte, number int) error {
+		entry, plain, encrypted, err := s.decodeLine(line)
+		if err != nil {
+			if invalid != nil {
+				keep, err := invalid(seg, line, number)
+				if err != nil {
+					return err
+				}
+				if !keep {
+					changed = true
+					return nil
+				}
+			}
+			// 無効な行は失わないようにそのまま書き戻す
+			buf.Write(line)
+			buf.WriteByte('\n')
+			lines++
+			return nil
+		}
 
-	sessionMap := make(map[string]bool)
-	scanner := bufio.NewScanner(file)
+		if !fn(&entry) {
+			changed = true
+			return nil
+		}
 
-	for scanner.Scan() {
-		var entry history.Entry
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
-			continue
+		// 内容も暗号化の有無も変わらない行は、暗号文を作り直さずにそのまま書き戻す
+		data, err := json.Marshal(&entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode entry %d: %w", entry.ID, err)
+		}
+		if bytes.Equal(data, plain) && encrypted == s.encrypting() {
+			buf.Write(line)
+			buf.WriteByte('\n')
+		} else {
+			changed = true
+			if data, err = s.encodeEntry(&entry); err != nil {
+				return fmt.Errorf("failed to encode entry %d: %w", entry.ID, err)
+			}
+			buf.Write(data)
 		}
-		sessionMap[entry.SessionID] = true
+		lines++
+		seg.add(&entry)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	reader.Close()
+
+	if !changed {
+		return lines == 0, nil
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read history file: %w", err)
+	err = writeAtomic(s.segmentPath(seg), func(w io.Writer) error {
+		if !seg.Compressed {
+			_, err := buf.WriteTo(w)
+			return err
+		}
+		gz := gzip.NewWriter(w)
+		if _, err := buf.WriteTo(gz); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to rewrite history segment %s: %w", seg.Name, err)
 	}
+	return lines == 0, nil
+}
+
+// Load フィルタ条件に基づいて履歴エントリを読み込み
+func (s *Storage) Load(filter history.EntryFilter) ([]history.Entry, error) {
+	entries := []history.Entry{}
+	err := s.Iterate(context.Background(), filter, func(entry history.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
 
-	var sessions []string
-	for session := range sessionMap {
-		sessions = append(sessions, session)
+// ErrNotFound 指定したIDの履歴エントリがない
+var ErrNotFound = errors.New("history entry not found")
+
+// GetByID IDにより特定の履歴エントリを取得（なければErrNotFound）
+func (s *Storage) GetByID(id int) (*history.Entry, error) {
+	var found *history.Entry
+	err := s.Iterate(context.Background(), history.EntryFilter{}, func(entry history.Entry) error {
+		if entry.ID != id {
+			return nil
+		}
+		found = &entry
+		return ErrStop
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
 	}
 
-	return sessions, nil
+	return found, nil
+}
+
+// ListSessions 全ての一意なセッションIDを返す
+func (s *Storage) ListSessions() ([]string, error) {
+	return s.uniqueValues(func(entry history.Entry) string {
+		return entry.SessionID
+	})
 }
 
 // ListDirectories 履歴を持つ全ての一意なディレクトリを返す
 func (s *Storage) ListDirectories() ([]string, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	return s.uniqueValues(func(entry history.Entry) string {
+		return entry.CWD
+	})
+}
 
-	file, err := os.Open(s.historyFile())
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
+// uniqueValues 全エントリから取り出した値を重複なく返す
+func (s *Storage) uniqueValues(value func(history.Entry) string) ([]string, error) {
+	seen := make(map[string]bool)
+	values := []string{}
+	err := s.Iterate(context.Background(), history.EntryFilter{}, func(entry history.Entry) error {
+		if v := value(entry); !seen[v] {
+			seen[v] = true
+			values = append(values, v)
 		}
-		return nil, fmt.Errorf("failed to open history file: %w", err)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	defer file.Close()
 
-	dirMap := make(map[string]bool)
-	scanner := bufio.NewScanner(file)
+	return values, nil
+}
 
-	for scanner.Scan() {
-		var entry history.Entry
-		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
-			continue
-		}
-		dirMap[entry.CWD] = true
+// matchesFilter エントリがフィルタ条件（順序・Offset・Limit以外）を満たすか判定
+// tree・search・exportなど全てのコマンドがこの判定を共有する
+func (s *Storage) matchesFilter(entry *history.Entry, filter history.EntryFilter) bool {
+	// ディレクトリはツリーと同じく、"cd build && make" をcd先で実行したものとして判定する
+	var dir string
+	if filter.CWD != nil || filter.CWDPrefix != nil {
+		dir = entry.ExecDir(s.homeDir)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read history file: %w", err)
+	switch {
+	case filter.SessionID != nil && entry.SessionID != *filter.SessionID:
+		return false
+	case filter.SessionIDs != nil && !containsString(filter.SessionIDs, entry.SessionID):
+		return false
+	case filter.CWD != nil && dir != *filter.CWD:
+		return false
+	case filter.CWDPrefix != nil && !underDirectory(dir, *filter.CWDPrefix):
+		return false
+	case filter.Failed && !entry.Failed():
+		return false
+	case filter.ExitCode != nil && (entry.ExitCode == nil || *entry.ExitCode != *filter.ExitCode):
+		return false
+	case filter.MinDuration > 0 && entry.Duration < filter.MinDuration:
+		return false
+	case filter.RepoRoot != nil && entry.RepoRoot != *filter.RepoRoot:
+		return false
+	case filter.Branch != nil && entry.Branch != *filter.Branch:
+		return false
+	case filter.Hostname != nil && entry.Hostname != *filter.Hostname:
+		return false
+	case filter.Username != nil && entry.Username != *filter.Username:
+		return false
+	case filter.Shell != nil && entry.Shell != *filter.Shell:
+		return false
+	case filter.TTY != nil && entry.TTY != *filter.TTY:
+		return false
+	case filter.TmuxPane != nil && entry.TmuxPane != *filter.TmuxPane:
+		return false
+	case !filter.Since.IsZero() && entry.Timestamp.Before(filter.Since):
+		return false
+	case !filter.Until.IsZero() && !entry.Timestamp.Before(filter.Until):
+		return false
+	case filter.CommandContains != "" && !strings.Contains(entry.Command, filter.CommandContains):
+		return false
+	case filter.CommandRegex != nil && !filter.CommandRegex.MatchString(entry.Command):
+		return false
 	}
+	return true
+}
 
-	var dirs []string
-	for dir := range dirMap {
-		dirs = append(dirs, dir)
+// underDirectory pathがdirそのものか、その配下にあるかを判定
+func underDirectory(path, dir string) bool {
+	if path == "" {
+		return false
+	}
+	dir = filepath.Clean(dir)
+	path = filepath.Clean(path)
+	if path == dir || dir == string(filepath.Separator) {
+		return true
 	}
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
 
-	return dirs, nil
+// containsString スライスに値が含まれるか判定
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
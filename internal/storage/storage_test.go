@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+const (
+	// stressWorkers 並行して記録するプロセス（またはStorage）の数、stressPerWorker それぞれが記録する件数
+	stressWorkers   = 8
+	stressPerWorker = 25
+
+	// stressWorkerEnv 設定されていれば、テストバイナリを記録だけ行う子プロセスとして動かす
+	// stressDirEnv 子プロセスが記録するストレージのディレクトリ
+	stressWorkerEnv = "RRK_STRESS_WORKER"
+	stressDirEnv    = "RRK_STRESS_DIR"
+)
+
+// saveStress 1つのStorageからstressPerWorker件を記録する
+func saveStress(store *Storage, worker string) error {
+	for i := 0; i < stressPerWorker; i++ {
+		entry := &history.Entry{SessionID: "stress", CWD: "/tmp", Command: fmt.Sprintf("echo worker-%s-%d", worker, i)}
+		if err := store.Save(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStress 全てのエントリが保存され、IDが重複していないことを確かめる
+func checkStress(t *testing.T, store *Storage) {
+	t.Helper()
+	entries, err := store.Load(history.EntryFilter{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	ids := make(map[int]string)
+	commands := make(map[string]bool)
+	for _, entry := range entries {
+		if other, ok := ids[entry.ID]; ok {
+			t.Errorf("ID %d is used by both %q and %q", entry.ID, other, entry.Command)
+		}
+		ids[entry.ID] = entry.Command
+		commands[entry.Command] = true
+	}
+	want := stressWorkers * stressPerWorker
+	if len(commands) != want {
+		t.Errorf("recorded %d distinct commands, want %d", len(commands), want)
+	}
+	if len(ids) != want {
+		t.Errorf("found %d distinct IDs, want %d", len(ids), want)
+	}
+}
+
+// TestConcurrentSave 同じディレクトリを開いた複数のStorageから並行して記録し、
+// 全てのエントリが保存され、IDが重複しないことを確かめる
+func TestConcurrentSave(t *testing.T) {
+	dir := t.TempDir()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, stressWorkers)
+	for w := 0; w < stressWorkers; w++ {
+		// Storageごとにロックファイルを開くため、記述子も別々になる
+		store, err := Open(dir)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := saveStress(store, strconv.Itoa(w)); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Save: %v", err)
+	}
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	checkStress(t, store)
+}
+
+// TestConcurrentSaveProcesses テストバイナリを別々のプロセスとして起動し、同じ履歴に並行して記録する
+// フックは記録のたびに別のrrkプロセスを起動するため、プロセス間のロックとIDカウンタを確かめる
+func TestConcurrentSaveProcesses(t *testing.T) {
+	if worker := os.Getenv(stressWorkerEnv); worker != "" {
+		store, err := Open(os.Getenv(stressDirEnv))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if err := saveStress(store, worker); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		return
+	}
+
+	dir := t.TempDir()
+	var procs []*exec.Cmd
+	outputs := make([]*safeBuffer, stressWorkers)
+	for w := 0; w < stressWorkers; w++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestConcurrentSaveProcesses$")
+		cmd.Env = append(os.Environ(), stressDirEnv+"="+dir, fmt.Sprintf("%s=p%d", stressWorkerEnv, w))
+		outputs[w] = &safeBuffer{}
+		cmd.Stdout, cmd.Stderr = outputs[w], outputs[w]
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("starting worker %d: %v", w, err)
+		}
+		procs = append(procs, cmd)
+	}
+	for w, cmd := range procs {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("worker %d failed: %v\n%s", w, err, outputs[w].String())
+		}
+	}
+	if t.Failed() {
+		return
+	}
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	checkStress(t, store)
+}
+
+// safeBuffer 子プロセスの出力を集める
+type safeBuffer struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *safeBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+// TestWriteCacheSeesOtherWriters メモリに状態を保持するStorageが、他のStorageの書き込み後に読み直し、
+// IDを重複させず、他のStorageが保存した記録要求を保存し直さないことを確かめる
+func TestWriteCacheSeesOtherWriters(t *testing.T) {
+	dir := t.TempDir()
+	cached, err := Open(dir, WithWriteCache())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	direct, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	save := func(store *Storage, command, recordID string) int {
+		t.Helper()
+		entry := &history.Entry{SessionID: "s", CWD: "/tmp", Command: command, RecordID: recordID}
+		if err := store.Save(entry); err != nil {
+			t.Fatalf("Save(%s): %v", command, err)
+		}
+		return entry.ID
+	}
+
+	ids := []int{
+		save(cached, "first", "r1"),
+		save(cached, "second", "r2"),
+		save(direct, "third", "r3"),
+		save(cached, "fourth", "r4"),
+	}
+	for i, id := range ids {
+		if id != i+1 {
+			t.Errorf("entry %d got ID %d, want %d", i, id, i+1)
+		}
+	}
+
+	// 再送された記録要求は、どちらが保存したものでも保存済みのIDを返す
+	if id := save(cached, "third", "r3"); id != 3 {
+		t.Errorf("resent r3 got ID %d, want 3", id)
+	}
+	if id := save(cached, "fourth", "r4"); id != 4 {
+		t.Errorf("resent r4 got ID %d, want 4", id)
+	}
+	if id := save(direct, "fourth", "r4"); id != 4 {
+		t.Errorf("resent r4 from another Storage got ID %d, want 4", id)
+	}
+
+	entries, err := direct.Load(history.EntryFilter{})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("stored %d entries, want 4", len(entries))
+	}
+}
+
+// TestOpenReadOnly 読み込み専用で開いたStorageが履歴を読めて、書き込みを拒否することを確かめる
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := writer.Save(&history.Entry{SessionID: "s", CWD: "/tmp", Command: "ls"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	store, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatalf("OpenReadOnly: %v", err)
+	}
+	if entry, err := store.GetByID(1); err != nil || entry.Command != "ls" {
+		t.Errorf("GetByID(1) = %+v, %v", entry, err)
+	}
+	if err := store.Save(&history.Entry{SessionID: "s", CWD: "/tmp", Command: "pwd"}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Save = %v, want ErrReadOnly", err)
+	}
+	if _, err := store.Delete(1); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Delete = %v, want ErrReadOnly", err)
+	}
+
+	if _, err := OpenReadOnly(filepath.Join(dir, "missing")); err == nil {
+		t.Error("OpenReadOnly succeeded for a missing directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Error("OpenReadOnly created the missing directory")
+	}
+}
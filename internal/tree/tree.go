@@ -2,11 +2,14 @@ package tree
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/shellparse"
 )
 
 // DirectoryNode ディレクトリツリーのノードを表現
@@ -31,41 +34,130 @@ func (node *DirectoryNode) AddCommand(command string) {
 }
 
 // TreeBuilder ディレクトリツリー構築器
+// Addでエントリを1件ずつ追加し、Buildでツリーを組み立てる
 type TreeBuilder struct {
-	root *DirectoryNode
+	root    *DirectoryNode
+	homeDir string
+	// SlowThreshold この時間以上かかったコマンドに実行時間を付記（0 = 付記しない）
+	SlowThreshold time.Duration
+	// GroupByBranch 同じコマンドでもブランチごとに分けて表示し、ブランチ名を付記
+	GroupByBranch bool
+
+	// dirs ディレクトリごとの重複除去済みエントリ
+	dirs map[string]*dirEntries
+}
+
+// dirEntries 1つのディレクトリの重複除去済みエントリ（初出の順序を保持）
+type dirEntries struct {
+	index   map[string]int
+	entries []history.Entry
 }
 
 // NewTreeBuilder 新しいツリー構築器を作成
 func NewTreeBuilder() *TreeBuilder {
+	homeDir, _ := os.UserHomeDir()
 	return &TreeBuilder{
-		root: NewDirectoryNode(""),
+		root:    NewDirectoryNode(""),
+		homeDir: homeDir,
+		dirs:    make(map[string]*dirEntries),
 	}
 }
 
-// BuildTree 履歴エントリからディレクトリツリーを構築
-func (tb *TreeBuilder) BuildTree(entries []history.Entry, limit int) *DirectoryNode {
-	// ディレクトリごとにコマンドをグループ化
+// Add エントリをツリーに追加（古い順に渡す）
+// 同じディレクトリの同じコマンドは1件にまとめるため、使用メモリは一意なコマンド数に比例する
+func (tb *TreeBuilder) Add(entry history.Entry) {
+	// "cd build && make" は cd 先のディレクトリに残りのコマンドとして分類
+	if dir, rest, ok := shellparse.ResolveLeadingCD(entry.Command, entry.CWD, tb.homeDir); ok && rest != "" {
+		entry.CWD = dir
+		entry.Command = rest
+	}
+	if entry.CWD == "" || entry.Command == "" {
+		return
+	}
+
+	dir := tb.dirs[entry.CWD]
+	if dir == nil {
+		dir = &dirEntries{index: make(map[string]int)}
+		tb.dirs[entry.CWD] = dir
+	}
+
+	// 重複は初出の位置に残し、内容は最新の実行で置き換え
+	key := tb.commandKey(entry)
+	if i, ok := dir.index[key]; ok {
+		dir.entries[i] = entry
+		return
+	}
+	dir.index[key] = len(dir.entries)
+	dir.entries = append(dir.entries, entry)
+}
+
+// Build 追加されたエントリからディレクトリツリーを構築（各ディレクトリ最新limit件、0は全件）
+func (tb *TreeBuilder) Build(limit int) *DirectoryNode {
 	dirCommands := make(map[string][]string)
-	
-	for _, entry := range entries {
-		if entry.CWD != "" && entry.Command != "" {
-			dirCommands[entry.CWD] = append(dirCommands[entry.CWD], entry.Command)
+	for path, dir := range tb.dirs {
+		entries := dir.entries
+		if limit > 0 && len(entries) > limit {
+			entries = entries[len(entries)-limit:]
 		}
-	}
-	
-	// 各ディレクトリで重複を除去し、制限を適用
-	for dir, commands := range dirCommands {
-		uniqueCommands := removeDuplicates(commands)
-		if limit > 0 && len(uniqueCommands) > limit {
-			uniqueCommands = uniqueCommands[len(uniqueCommands)-limit:]
+		commands := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			commands = append(commands, tb.formatCommand(entry))
 		}
-		dirCommands[dir] = uniqueCommands
+		dirCommands[path] = commands
 	}
-	
+
 	// ツリー構造を構築
 	return tb.buildDirectoryTree(dirCommands)
 }
 
+// BuildTree 履歴エントリからディレクトリツリーを構築
+func (tb *TreeBuilder) BuildTree(entries []history.Entry, limit int) *DirectoryNode {
+	for _, entry := range entries {
+		tb.Add(entry)
+	}
+	return tb.Build(limit)
+}
+
+// commandKey 重複判定に使うキーを返す
+func (tb *TreeBuilder) commandKey(entry history.Entry) string {
+	if tb.GroupByBranch {
+		return entry.Branch + "\x00" + entry.Command
+	}
+	return entry.Command
+}
+
+// formatCommand 終了ステータスと実行時間を付記したコマンド表示を返す
+func (tb *TreeBuilder) formatCommand(entry history.Entry) string {
+	command := entry.Command
+	if tb.GroupByBranch && entry.Branch != "" {
+		command = fmt.Sprintf("%s @%s", command, entry.Branch)
+	}
+
+	var notes []string
+	if entry.Failed() {
+		notes = append(notes, fmt.Sprintf("exit %d", *entry.ExitCode))
+	}
+	if tb.SlowThreshold > 0 && entry.Duration >= tb.SlowThreshold {
+		notes = append(notes, formatDuration(entry.Duration))
+	}
+	if entry.Truncated {
+		notes = append(notes, "truncated")
+	}
+
+	if len(notes) == 0 {
+		return command
+	}
+	return fmt.Sprintf("%s [%s]", command, strings.Join(notes, ", "))
+}
+
+// formatDuration 実行時間を表示用に丸める
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
 // buildDirectoryTree ディレクトリマップからツリー構造を構築
 func (tb *TreeBuilder) buildDirectoryTree(dirCommands map[string][]string) *DirectoryNode {
 	root := NewDirectoryNode("")
@@ -401,18 +493,3 @@ func printCommands(commands []string, prefix string, maxCommands int) {
 		}
 	}
 }
-
-// removeDuplicates 重複を除去（順序を保持）
-func removeDuplicates(slice []string) []string {
-	seen := make(map[string]bool)
-	var result []string
-	
-	for _, item := range slice {
-		if !seen[item] {
-			seen[item] = true
-			result = append(result, item)
-		}
-	}
-	
-	return result
-}
\ No newline at end of file
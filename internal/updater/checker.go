@@ -9,6 +9,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/MRyutaro/rrk/internal/paths"
 )
 
 type GitHubRelease struct {
@@ -129,11 +131,11 @@ func fetchLatestVersion() string {
 }
 
 func getCacheFilePath() string {
-	homeDir, err := os.UserHomeDir()
+	root, err := paths.RootDir()
 	if err != nil {
 		return ""
 	}
-	return filepath.Join(homeDir, ".rrk", cacheFile)
+	return filepath.Join(root, cacheFile)
 }
 
 func loadCache() VersionCache {
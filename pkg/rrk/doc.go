@@ -0,0 +1,17 @@
+// Package rrk rrkが記録したコマンド履歴を他のGoプログラムから読み込み、検索するための公開パッケージ
+//
+// internal以下のパッケージは予告なく変更されるが、このパッケージで公開している型・関数・メソッドは
+// セマンティックバージョニングに従い、同じメジャーバージョンの間は互換性を保つ（削除・シグネチャの変更をしない）。
+// 構造体へのフィールドの追加と、新しい関数・メソッドの追加はマイナーバージョンで行うことがあるため、
+// 構造体はフィールド名を指定して初期化すること。
+//
+// 履歴の読み込みのみを提供し、記録・削除などの書き込みはrrkコマンドで行う。
+// 履歴は読み込み専用で開き、ファイルの作成・旧形式の履歴の移行・書き込み用のロックの取得は行わない。
+// 暗号化された履歴は、rrkコマンドと同じく鍵ファイルまたは RRK_PASSPHRASE で復号する。
+//
+//	store, err := rrk.OpenDefault()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	entries, err := store.Entries(ctx, rrk.Filter{Failed: true, Newest: true, Limit: 10})
+package rrk
@@ -0,0 +1,160 @@
+package rrk
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/history"
+)
+
+// Entry 記録された1件のコマンド
+type Entry struct {
+	ID        int
+	SessionID string
+	// Dir コマンドを実行したディレクトリ
+	Dir       string
+	Command   string
+	Timestamp time.Time
+	// ExitCode 終了ステータス（記録されていなければnil）
+	ExitCode *int
+	// Duration 実行時間（記録されていなければ0）
+	Duration time.Duration
+
+	// RepoRoot, Branch, Commit Gitリポジトリ内で実行した場合のリポジトリの状態
+	RepoRoot string
+	Branch   string
+	Commit   string
+
+	Hostname     string
+	Username     string
+	Shell        string
+	ShellVersion string
+	TTY          string
+	TmuxPane     string
+
+	// Truncated 長すぎるコマンドを記録時に切り詰めたか
+	Truncated bool
+	// Imported 他のシェル履歴から取り込んだコマンドか
+	Imported bool
+}
+
+// Failed コマンドが非ゼロの終了ステータスで終了したかを返す
+func (e Entry) Failed() bool {
+	return e.ExitCode != nil && *e.ExitCode != 0
+}
+
+// fromHistory 内部の履歴エントリを公開用のEntryに変換
+func fromHistory(h history.Entry) Entry {
+	return Entry{
+		ID:           h.ID,
+		SessionID:    h.SessionID,
+		Dir:          h.CWD,
+		Command:      h.Command,
+		Timestamp:    h.Timestamp,
+		ExitCode:     h.ExitCode,
+		Duration:     h.Duration,
+		RepoRoot:     h.RepoRoot,
+		Branch:       h.Branch,
+		Commit:       h.Commit,
+		Hostname:     h.Hostname,
+		Username:     h.Username,
+		Shell:        h.Shell,
+		ShellVersion: h.ShellVersion,
+		TTY:          h.TTY,
+		TmuxPane:     h.TmuxPane,
+		Truncated:    h.Truncated,
+		Imported:     h.ImportKey != "",
+	}
+}
+
+// toHistory ツリーの構築用に内部の履歴エントリに戻す
+func (e Entry) toHistory() history.Entry {
+	return history.Entry{
+		ID:           e.ID,
+		SessionID:    e.SessionID,
+		CWD:          e.Dir,
+		Command:      e.Command,
+		Timestamp:    e.Timestamp,
+		ExitCode:     e.ExitCode,
+		Duration:     e.Duration,
+		RepoRoot:     e.RepoRoot,
+		Branch:       e.Branch,
+		Commit:       e.Commit,
+		Hostname:     e.Hostname,
+		Username:     e.Username,
+		Shell:        e.Shell,
+		ShellVersion: e.ShellVersion,
+		TTY:          e.TTY,
+		TmuxPane:     e.TmuxPane,
+		Truncated:    e.Truncated,
+	}
+}
+
+// Filter 検索条件（ゼロ値の項目は条件にしない。全ての条件を満たすエントリが一致する）
+type Filter struct {
+	// SessionIDs いずれかのセッションで実行されたもの
+	SessionIDs []string
+	// Dir このディレクトリで実行されたもの、DirPrefix このディレクトリ以下（サブツリー全体）で実行されたもの
+	// "cd build && make" のように先頭でcdするコマンドは、ツリーと同じくcd先で実行されたものとして扱う
+	Dir       string
+	DirPrefix string
+	RepoRoot  string
+	Branch    string
+	Hostname  string
+	Username  string
+	Shell     string
+
+	// Failed 非ゼロの終了ステータスで終了したもの、ExitCode 指定した終了ステータスのもの
+	Failed   bool
+	ExitCode *int
+	// MinDuration この時間以上かかったもの
+	MinDuration time.Duration
+
+	// Since この時刻以降（含む）、Until この時刻より前に実行されたもの
+	Since time.Time
+	Until time.Time
+
+	// Contains コマンドにこの文字列を含むもの、Regexp コマンドがこの正規表現にマッチするもの
+	Contains string
+	Regexp   *regexp.Regexp
+
+	// Newest 新しい順に返す（LimitとOffsetも新しい側から数える）
+	Newest bool
+	// Offset 一致したもののうち先頭から読み飛ばす件数、Limit 返す最大件数（0は全件）
+	Offset int
+	Limit  int
+}
+
+// toHistory 内部の検索条件に変換
+func (f Filter) toHistory() history.EntryFilter {
+	optional := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+	filter := history.EntryFilter{
+		CWD:             optional(f.Dir),
+		CWDPrefix:       optional(f.DirPrefix),
+		RepoRoot:        optional(f.RepoRoot),
+		Branch:          optional(f.Branch),
+		Hostname:        optional(f.Hostname),
+		Username:        optional(f.Username),
+		Shell:           optional(f.Shell),
+		Failed:          f.Failed,
+		ExitCode:        f.ExitCode,
+		MinDuration:     f.MinDuration,
+		Since:           f.Since,
+		Until:           f.Until,
+		CommandContains: f.Contains,
+		CommandRegex:    f.Regexp,
+		Newest:          f.Newest,
+		Offset:          f.Offset,
+		Limit:           f.Limit,
+	}
+	// 内部の条件では空のスライスはどのセッションにも一致しないため、指定がなければnilのままにする
+	if len(f.SessionIDs) > 0 {
+		filter.SessionIDs = f.SessionIDs
+	}
+	return filter
+}
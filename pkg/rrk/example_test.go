@@ -0,0 +1,100 @@
+package rrk_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/MRyutaro/rrk/pkg/rrk"
+)
+
+func ExampleOpen() {
+	// 通常は rrk.OpenDefault() で rrk コマンドと同じ履歴を開く
+	store, err := rrk.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries, err := store.Entries(context.Background(), rrk.Filter{Newest: true, Limit: 2})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, entry := range entries {
+		fmt.Printf("%d %s %s\n", entry.ID, entry.Dir, entry.Command)
+	}
+	// Output:
+	// 6 /tmp cd /home/dev/app && make lint
+	// 5 /home/dev/app make test
+}
+
+func ExampleStore_Entries() {
+	store, err := rrk.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// /home/dev/app 以下で失敗したコマンド（"cd /home/dev/app && ..." も含む）
+	failed, err := store.Entries(context.Background(), rrk.Filter{DirPrefix: "/home/dev/app", Failed: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, entry := range failed {
+		fmt.Printf("%s exit %d: %s\n", entry.Timestamp.Format("15:04"), *entry.ExitCode, entry.Command)
+	}
+	// Output:
+	// 09:05 exit 2: make test
+	// 10:30 exit 1: cd /home/dev/app && make lint
+}
+
+func ExampleStore_Each() {
+	store, err := rrk.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 全件をメモリに読み込まずに集計する
+	runs := map[string]int{}
+	err = store.Each(context.Background(), rrk.Filter{}, func(entry rrk.Entry) error {
+		runs[strings.Fields(entry.Command)[0]]++
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(runs["make"], runs["npm"], runs["ls"])
+	// Output:
+	// 3 1 1
+}
+
+func ExampleStore_Tree() {
+	store, err := rrk.Open("testdata")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	root, err := store.Tree(context.Background(), rrk.Filter{}, rrk.TreeOptions{Limit: 2})
+	if err != nil {
+		log.Fatal(err)
+	}
+	root.Walk(func(node *rrk.TreeNode, depth int) {
+		// ルート（depth 0）は名前のないノードなので、その子から字下げする
+		indent := strings.Repeat("  ", max(depth-1, 0))
+		if node.Name != "" {
+			fmt.Printf("%s%s/\n", indent, node.Name)
+			indent += "  "
+		}
+		for _, command := range node.Commands {
+			fmt.Printf("%s- %s\n", indent, command)
+		}
+	})
+	// Output:
+	// home/
+	//   dev/
+	//     - ls -la
+	//     app/
+	//       - make test
+	//       - make lint [exit 1]
+	//       web/
+	//         - npm start
+}
@@ -0,0 +1,377 @@
+package rrk_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/MRyutaro/rrk/pkg/rrk"
+)
+
+// fixture testdata には2024-05-01に記録した6件のコマンドが保存されている
+const fixture = "testdata"
+
+func openFixture(t *testing.T) *rrk.Store {
+	t.Helper()
+	store, err := rrk.Open(fixture)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return store
+}
+
+func commands(entries []rrk.Entry) []string {
+	result := []string{}
+	for _, entry := range entries {
+		result = append(result, entry.Command)
+	}
+	return result
+}
+
+func TestOpenMissingDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	if _, err := rrk.Open(dir); err == nil {
+		t.Fatal("Open succeeded for a missing directory")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Open created %s", dir)
+	}
+}
+
+// snapshot ディレクトリ内の全てのファイルのサイズと更新時刻を返す
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[path] = fmt.Sprintf("%d %s", info.Size(), info.ModTime())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestOpenDoesNotWrite 読み込み専用のOpenが、ファイルの作成・変更やロックファイルの作成を行わないことを確かめる
+func TestOpenDoesNotWrite(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS(fixture)); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshot(t, dir)
+
+	store, err := rrk.Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := store.Entries(context.Background(), rrk.Filter{}); err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	if _, err := store.Sessions(); err != nil {
+		t.Fatalf("Sessions: %v", err)
+	}
+
+	if after := snapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Errorf("files changed while reading:\nbefore %v\nafter  %v", before, after)
+	}
+}
+
+// TestOpenLegacyHistory 旧形式の履歴は移行せず、エラーを返すことを確かめる
+func TestOpenLegacyHistory(t *testing.T) {
+	dir := t.TempDir()
+	line := `{"id":1,"session_id":"s","cwd":"/tmp","command":"ls","timestamp":"2024-05-01T09:00:00Z"}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "history.jsonl"), []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before := snapshot(t, dir)
+
+	if _, err := rrk.Open(dir); err == nil {
+		t.Error("Open succeeded for unmigrated history")
+	}
+	if after := snapshot(t, dir); !reflect.DeepEqual(before, after) {
+		t.Errorf("Open changed the directory: %v", after)
+	}
+}
+
+func TestOpenDefaultHonorsRRKHome(t *testing.T) {
+	abs, err := filepath.Abs(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RRK_HOME", abs)
+	t.Setenv("RRK_PROFILE", "")
+
+	store, err := rrk.OpenDefault()
+	if err != nil {
+		t.Fatalf("OpenDefault: %v", err)
+	}
+	if store.Dir() != abs {
+		t.Errorf("Dir() = %q, want %q", store.Dir(), abs)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	store := openFixture(t)
+	entries, err := store.Entries(context.Background(), rrk.Filter{})
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+
+	want := []string{"make build", "make test", "npm start", "ls -la", "make test", "cd /home/dev/app && make lint"}
+	if got := commands(entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("commands = %q, want %q", got, want)
+	}
+
+	first := entries[0]
+	exitCode := 0
+	wantFirst := rrk.Entry{
+		ID:           1,
+		SessionID:    "s1",
+		Dir:          "/home/dev/app",
+		Command:      "make build",
+		Timestamp:    time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC),
+		ExitCode:     &exitCode,
+		Duration:     2 * time.Second,
+		RepoRoot:     "/home/dev/app",
+		Branch:       "main",
+		Commit:       "3f2c1a9",
+		Hostname:     "laptop",
+		Username:     "dev",
+		Shell:        "zsh",
+		ShellVersion: "5.9",
+	}
+	if !reflect.DeepEqual(first, wantFirst) {
+		t.Errorf("first entry = %+v, want %+v", first, wantFirst)
+	}
+	if entries[2].TTY != "/dev/ttys001" {
+		t.Errorf("TTY = %q, want /dev/ttys001", entries[2].TTY)
+	}
+}
+
+func TestEntriesFilter(t *testing.T) {
+	store := openFixture(t)
+	exitCode := 0
+	tests := []struct {
+		name   string
+		filter rrk.Filter
+		want   []string
+	}{
+		{"failed newest first", rrk.Filter{Failed: true, Newest: true}, []string{"cd /home/dev/app && make lint", "make test"}},
+		{"limit and offset", rrk.Filter{Newest: true, Offset: 1, Limit: 2}, []string{"make test", "ls -la"}},
+		{"exact directory", rrk.Filter{Dir: "/home/dev/app/web"}, []string{"npm start"}},
+		// 先頭でcdするコマンドはcd先のディレクトリで実行されたものとして扱う
+		{"directory subtree", rrk.Filter{DirPrefix: "/home/dev/app"}, []string{"make build", "make test", "npm start", "make test", "cd /home/dev/app && make lint"}},
+		{"sessions", rrk.Filter{SessionIDs: []string{"s2"}}, []string{"ls -la", "make test", "cd /home/dev/app && make lint"}},
+		{"empty sessions match all", rrk.Filter{SessionIDs: []string{}, Limit: 1}, []string{"make build"}},
+		{"exit code", rrk.Filter{ExitCode: &exitCode, Branch: "main"}, []string{"make build", "npm start", "make test"}},
+		{"min duration", rrk.Filter{MinDuration: 5 * time.Second}, []string{"make test", "make test"}},
+		{"contains", rrk.Filter{Contains: "make"}, []string{"make build", "make test", "make test", "cd /home/dev/app && make lint"}},
+		{"regexp", rrk.Filter{Regexp: regexp.MustCompile(`^(npm|ls)\b`)}, []string{"npm start", "ls -la"}},
+		{"time range", rrk.Filter{
+			Since: time.Date(2024, 5, 1, 9, 5, 0, 0, time.UTC),
+			Until: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		}, []string{"make test", "npm start", "ls -la"}},
+		{"no match", rrk.Filter{Hostname: "server"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := store.Entries(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("Entries: %v", err)
+			}
+			if got := commands(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEachStop(t *testing.T) {
+	store := openFixture(t)
+	count := 0
+	err := store.Each(context.Background(), rrk.Filter{}, func(entry rrk.Entry) error {
+		count++
+		if count == 2 {
+			return rrk.ErrStop
+		}
+		return nil
+	})
+	if err != nil || count != 2 {
+		t.Errorf("Each = %v after %d entries, want nil after 2", err, count)
+	}
+
+	failure := errors.New("failure")
+	err = store.Each(context.Background(), rrk.Filter{}, func(rrk.Entry) error { return failure })
+	if !errors.Is(err, failure) {
+		t.Errorf("Each = %v, want %v", err, failure)
+	}
+}
+
+func TestGet(t *testing.T) {
+	store := openFixture(t)
+	entry, err := store.Get(context.Background(), 3)
+	if err != nil || entry.Command != "npm start" {
+		t.Errorf("Get(3) = %q, %v, want \"npm start\"", entry.Command, err)
+	}
+	if _, err := store.Get(context.Background(), 99); !errors.Is(err, rrk.ErrNotFound) {
+		t.Errorf("Get(99) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSessionsAndDirectories(t *testing.T) {
+	store := openFixture(t)
+	sessions, err := store.Sessions()
+	if err != nil || !reflect.DeepEqual(sessions, []string{"s1", "s2"}) {
+		t.Errorf("Sessions() = %q, %v", sessions, err)
+	}
+	dirs, err := store.Directories()
+	want := []string{"/home/dev/app", "/home/dev/app/web", "/home/dev", "/tmp"}
+	if err != nil || !reflect.DeepEqual(dirs, want) {
+		t.Errorf("Directories() = %q, %v, want %q", dirs, err, want)
+	}
+}
+
+func TestTree(t *testing.T) {
+	store := openFixture(t)
+	root, err := store.Tree(context.Background(), rrk.Filter{Newest: true}, rrk.TreeOptions{SlowThreshold: 10 * time.Second})
+	if err != nil {
+		t.Fatalf("Tree: %v", err)
+	}
+
+	app := root.Find("/home/dev/app")
+	if app == nil {
+		t.Fatal("Find(/home/dev/app) = nil")
+	}
+	// "cd /home/dev/app && make lint" は cd 先のディレクトリに分類される
+	want := []string{"make build", "make test", "make lint [exit 1]"}
+	if app.Name != "app" || !reflect.DeepEqual(app.Commands, want) {
+		t.Errorf("app node = %q %q, want \"app\" %q", app.Name, app.Commands, want)
+	}
+	if root.Find("/tmp") != nil {
+		t.Error("Find(/tmp) found a node for a command that was filed under its cd target")
+	}
+
+	var paths []string
+	root.Walk(func(node *rrk.TreeNode, depth int) {
+		paths = append(paths, node.Path)
+	})
+	wantPaths := []string{"", "/home", "/home/dev", "/home/dev/app", "/home/dev/app/web"}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("Walk paths = %q, want %q", paths, wantPaths)
+	}
+}
+
+func TestBuildTreeUsesEveryField(t *testing.T) {
+	exitCode := 1
+	entries := []rrk.Entry{
+		{Dir: "/src", Command: "go test", Branch: "main", ExitCode: &exitCode, Duration: time.Minute, Truncated: true},
+		{Dir: "/src", Command: "go test", Branch: "feature"},
+	}
+	root := rrk.BuildTree(entries, rrk.TreeOptions{GroupByBranch: true, SlowThreshold: time.Second})
+	want := []string{"go test @main [exit 1, 1m0s, truncated]", "go test @feature"}
+	if got := root.Find("/src").Commands; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+// TestExportedSurface 公開している型のフィールドとメソッドを固定する
+// 互換性を壊す変更（削除・名前や型の変更）をするとこのテストが失敗する。追加した場合はここにも追加する
+func TestExportedSurface(t *testing.T) {
+	fields := func(v any) map[string]string {
+		result := map[string]string{}
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			if field := typ.Field(i); field.IsExported() {
+				result[field.Name] = field.Type.String()
+			}
+		}
+		return result
+	}
+	methods := func(v any) []string {
+		result := []string{}
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumMethod(); i++ {
+			method := typ.Method(i)
+			result = append(result, method.Name+" "+method.Type.String())
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	wantFields := map[string]map[string]string{
+		"Entry": {
+			"ID": "int", "SessionID": "string", "Dir": "string", "Command": "string",
+			"Timestamp": "time.Time", "ExitCode": "*int", "Duration": "time.Duration",
+			"RepoRoot": "string", "Branch": "string", "Commit": "string",
+			"Hostname": "string", "Username": "string", "Shell": "string", "ShellVersion": "string",
+			"TTY": "string", "TmuxPane": "string", "Truncated": "bool", "Imported": "bool",
+		},
+		"Filter": {
+			"SessionIDs": "[]string", "Dir": "string", "DirPrefix": "string", "RepoRoot": "string",
+			"Branch": "string", "Hostname": "string", "Username": "string", "Shell": "string",
+			"Failed": "bool", "ExitCode": "*int", "MinDuration": "time.Duration",
+			"Since": "time.Time", "Until": "time.Time", "Contains": "string", "Regexp": "*regexp.Regexp",
+			"Newest": "bool", "Offset": "int", "Limit": "int",
+		},
+		"TreeOptions": {"Limit": "int", "SlowThreshold": "time.Duration", "GroupByBranch": "bool"},
+		"TreeNode":    {"Name": "string", "Path": "string", "Commands": "[]string", "Children": "[]*rrk.TreeNode"},
+	}
+	gotFields := map[string]map[string]string{
+		"Entry":       fields(rrk.Entry{}),
+		"Filter":      fields(rrk.Filter{}),
+		"TreeOptions": fields(rrk.TreeOptions{}),
+		"TreeNode":    fields(rrk.TreeNode{}),
+	}
+	if !reflect.DeepEqual(gotFields, wantFields) {
+		t.Errorf("exported fields = %v\nwant %v", gotFields, wantFields)
+	}
+
+	wantMethods := map[string][]string{
+		"Entry": {"Failed func(rrk.Entry) bool"},
+		"*Store": {
+			"Dir func(*rrk.Store) string",
+			"Directories func(*rrk.Store) ([]string, error)",
+			"Each func(*rrk.Store, context.Context, rrk.Filter, func(rrk.Entry) error) error",
+			"Entries func(*rrk.Store, context.Context, rrk.Filter) ([]rrk.Entry, error)",
+			"Get func(*rrk.Store, context.Context, int) (rrk.Entry, error)",
+			"Sessions func(*rrk.Store) ([]string, error)",
+			"Tree func(*rrk.Store, context.Context, rrk.Filter, rrk.TreeOptions) (*rrk.TreeNode, error)",
+		},
+		"*TreeNode": {
+			"Find func(*rrk.TreeNode, string) *rrk.TreeNode",
+			"Walk func(*rrk.TreeNode, func(*rrk.TreeNode, int))",
+		},
+	}
+	gotMethods := map[string][]string{
+		"Entry":     methods(rrk.Entry{}),
+		"*Store":    methods(&rrk.Store{}),
+		"*TreeNode": methods(&rrk.TreeNode{}),
+	}
+	if !reflect.DeepEqual(gotMethods, wantMethods) {
+		t.Errorf("exported methods = %q\nwant %q", gotMethods, wantMethods)
+	}
+
+	// 関数と変数はシグネチャが変わるとコンパイルできなくなる
+	var (
+		_ func(string) (*rrk.Store, error)                 = rrk.Open
+		_ func() (*rrk.Store, error)                       = rrk.OpenDefault
+		_ func() (string, error)                           = rrk.DefaultDir
+		_ func([]rrk.Entry, rrk.TreeOptions) *rrk.TreeNode = rrk.BuildTree
+		_ error                                            = rrk.ErrStop
+		_ error                                            = rrk.ErrNotFound
+	)
+}
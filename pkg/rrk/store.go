@@ -0,0 +1,124 @@
+package rrk
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MRyutaro/rrk/internal/config"
+	"github.com/MRyutaro/rrk/internal/history"
+	"github.com/MRyutaro/rrk/internal/paths"
+	"github.com/MRyutaro/rrk/internal/storage"
+)
+
+var (
+	// ErrStop Eachのコールバックが返すと、走査をエラーなしで終了する
+	ErrStop = errors.New("stop iteration")
+	// ErrNotFound 指定したIDのエントリがない
+	ErrNotFound = errors.New("history entry not found")
+)
+
+// Store rrkの履歴の読み込み専用のハンドル
+// 複数のゴルーチンから同時に使ってよく、記録中のrrkコマンドとも同時に読み込める
+type Store struct {
+	storage *storage.Storage
+}
+
+// Open 指定したデータディレクトリ（~/.rrk、またはプロファイルのディレクトリ）の履歴を読み込み専用で開く
+// ディレクトリやファイルを作成・変更せず、書き込み用のロックも取得しないため、記録中のrrkを待たせることはない
+func Open(dir string) (*Store, error) {
+	return open(dir)
+}
+
+// OpenDefault rrkコマンドと同じ規則（RRK_HOME、XDG_DATA_HOME、RRK_PROFILE など）で決まるデータディレクトリの履歴を開く
+// 設定ファイルの key_file で鍵ファイルをデータディレクトリの外に置いている場合も、その鍵で復号する
+func OpenDefault() (*Store, error) {
+	dir, err := DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := cfg.KeyFilePath()
+	if err != nil {
+		return nil, err
+	}
+	return open(dir, storage.WithKeyFile(keyFile))
+}
+
+// open 履歴を読み込み専用で開く
+func open(dir string, opts ...storage.Option) (*Store, error) {
+	s, err := storage.OpenReadOnly(dir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{storage: s}, nil
+}
+
+// DefaultDir rrkコマンドが使用中のプロファイルの履歴を保存しているディレクトリを返す
+func DefaultDir() (string, error) {
+	return paths.DataDir()
+}
+
+// Dir 履歴を読み込んでいるディレクトリを返す
+func (s *Store) Dir() string {
+	return s.storage.Path()
+}
+
+// Each 条件に一致するエントリを古い順（filter.Newestなら新しい順）にfnへ渡す
+// 全件をメモリに読み込まないため、大きな履歴でも使用メモリは一定に保たれる
+// fnがErrStopを返すとnilを返して終了し、それ以外のエラーはそのまま返す
+func (s *Store) Each(ctx context.Context, filter Filter, fn func(Entry) error) error {
+	return s.storage.Iterate(ctx, filter.toHistory(), func(entry history.Entry) error {
+		if err := fn(fromHistory(entry)); err != nil {
+			if errors.Is(err, ErrStop) {
+				return storage.ErrStop
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// Entries 条件に一致するエントリを古い順（filter.Newestなら新しい順）に返す
+func (s *Store) Entries(ctx context.Context, filter Filter) ([]Entry, error) {
+	entries := []Entry{}
+	err := s.Each(ctx, filter, func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Get IDを指定してエントリを返す（なければErrNotFound）
+func (s *Store) Get(ctx context.Context, id int) (Entry, error) {
+	var found *Entry
+	err := s.Each(ctx, Filter{}, func(entry Entry) error {
+		if entry.ID != id {
+			return nil
+		}
+		found = &entry
+		return ErrStop
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+	if found == nil {
+		return Entry{}, ErrNotFound
+	}
+	return *found, nil
+}
+
+// Sessions 履歴のある全てのセッションIDを、最初に現れた順に返す
+func (s *Store) Sessions() ([]string, error) {
+	return s.storage.ListSessions()
+}
+
+// Directories 履歴のある全てのディレクトリを、最初に現れた順に返す
+func (s *Store) Directories() ([]string, error) {
+	return s.storage.ListDirectories()
+}
@@ -0,0 +1,107 @@
+package rrk
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/MRyutaro/rrk/internal/tree"
+)
+
+// TreeOptions ツリーの構築方法
+type TreeOptions struct {
+	// Limit 各ディレクトリに残す最新のコマンド数（0は全件）
+	Limit int
+	// SlowThreshold この時間以上かかったコマンドに実行時間を付記する（0は付記しない）
+	SlowThreshold time.Duration
+	// GroupByBranch 同じコマンドでもブランチごとに分け、ブランチ名を付記する
+	GroupByBranch bool
+}
+
+// TreeNode rrkのツリー表示と同じ、ディレクトリごとにコマンドをまとめたツリーのノード
+type TreeNode struct {
+	// Name ディレクトリ名（ルートは空文字列）、Path ディレクトリの絶対パス（ルートは空文字列）
+	Name string
+	Path string
+	// Commands このディレクトリで実行したコマンド（重複を除き古い順。終了ステータスなどの注記を含む）
+	Commands []string
+	// Children サブディレクトリ（名前順）
+	Children []*TreeNode
+}
+
+// Find pathのノードを返す（なければnil）
+func (n *TreeNode) Find(path string) *TreeNode {
+	path = filepath.Clean(path)
+	if n.Path == path || (n.Path == "" && path == string(filepath.Separator)) {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := child.Find(path); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Walk ノードとその子孫を深さ優先・名前順にfnへ渡す（depthはルートが0）
+func (n *TreeNode) Walk(fn func(node *TreeNode, depth int)) {
+	n.walk(fn, 0)
+}
+
+func (n *TreeNode) walk(fn func(node *TreeNode, depth int), depth int) {
+	fn(n, depth)
+	for _, child := range n.Children {
+		child.walk(fn, depth+1)
+	}
+}
+
+// BuildTree エントリ（古い順）からディレクトリツリーを構築
+func BuildTree(entries []Entry, opts TreeOptions) *TreeNode {
+	builder := newTreeBuilder(opts)
+	for _, entry := range entries {
+		builder.Add(entry.toHistory())
+	}
+	return fromDirectoryNode("", builder.Build(opts.Limit))
+}
+
+// Tree 条件に一致するエントリからディレクトリツリーを構築（全件をメモリに読み込まない）
+// filter.Newestは無視し、常に古い順に追加する
+func (s *Store) Tree(ctx context.Context, filter Filter, opts TreeOptions) (*TreeNode, error) {
+	builder := newTreeBuilder(opts)
+	filter.Newest = false
+	err := s.Each(ctx, filter, func(entry Entry) error {
+		builder.Add(entry.toHistory())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromDirectoryNode("", builder.Build(opts.Limit)), nil
+}
+
+// newTreeBuilder オプションを反映したツリー構築器を作成
+func newTreeBuilder(opts TreeOptions) *tree.TreeBuilder {
+	builder := tree.NewTreeBuilder()
+	builder.SlowThreshold = opts.SlowThreshold
+	builder.GroupByBranch = opts.GroupByBranch
+	return builder
+}
+
+// fromDirectoryNode 内部のツリーを、子を名前順に並べた公開用のツリーに変換
+func fromDirectoryNode(name string, node *tree.DirectoryNode) *TreeNode {
+	converted := &TreeNode{
+		Name:     name,
+		Path:     node.Path,
+		Commands: append([]string(nil), node.Commands...),
+	}
+	names := make([]string, 0, len(node.Children))
+	for childName := range node.Children {
+		names = append(names, childName)
+	}
+	sort.Strings(names)
+	for _, childName := range names {
+		converted.Children = append(converted.Children, fromDirectoryNode(childName, node.Children[childName]))
+	}
+	return converted
+}
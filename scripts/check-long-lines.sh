@@ -0,0 +1,48 @@
+#!/bin/bash
+
+# 巨大な履歴行の回帰テスト
+# 数MBのコマンドを含む履歴でも読み込み・記録ができること、長すぎるコマンドが切り詰められることを確認する
+# 使用法: ./check-long-lines.sh
+
+set -e
+
+WORKDIR=$(mktemp -d)
+trap 'rm -rf "$WORKDIR"' EXIT
+RRK="$WORKDIR/rrk"
+
+echo "Building rrk..."
+go build -o "$RRK" "$(dirname "$0")/.."
+
+# 一時的なHOMEで実行し、実際の履歴には触れない
+export HOME="$WORKDIR/home"
+export RRK_SESSION_ID=long-lines
+mkdir -p "$HOME/.rrk"
+
+fail() {
+    echo "❌ $1"
+    exit 1
+}
+
+# 旧形式の履歴ファイルに3MBのコマンドを含む行を直接書き込む
+# （初回の読み込みでセグメント形式に移行され、カウンタがないため次のIDは移行した履歴から決まる）
+BIG=$(head -c 3000000 /dev/zero | tr '\0' x)
+printf '{"id":1,"session_id":"long-lines","cwd":"/tmp/big","command":"echo %s","timestamp":"2024-01-01T00:00:00Z"}\n' "$BIG" > "$HOME/.rrk/history.jsonl"
+
+echo "Recording after a 3MB history line..."
+"$RRK" hook record --cwd /tmp/small -- "echo small" || fail "recording failed"
+"$RRK" export --dir /tmp/small | grep -q '"id":2,.*"echo small"' || fail "new entry did not get ID 2"
+
+echo "Reading a history with a 3MB line..."
+"$RRK" /tmp/small | grep -q "echo small" || fail "tree did not show the entry after the long line"
+"$RRK" export --newest -n 1 --dir /tmp/big | grep -q '"id":1,' || fail "the long line was not read back"
+"$RRK" sessions | grep -q long-lines || fail "sessions could not be listed"
+
+echo "Truncating a command over max_command_bytes..."
+echo '{"max_command_bytes": 1000}' > "$HOME/.rrk/config.json"
+"$RRK" hook record --cwd /tmp/trunc -- "echo $(head -c 5000 /dev/zero | tr '\0' y)"
+LINE=$("$RRK" export --dir /tmp/trunc) || fail "truncated command was not recorded"
+echo "$LINE" | grep -q '"truncated":true' || fail "entry was not marked as truncated"
+COMMAND=$(echo "$LINE" | grep -o '"command":"[^"]*"')
+[ "${#COMMAND}" -le 1012 ] || fail "command was not truncated to 1000 bytes"
+
+echo "✅ Long lines are handled"
@@ -0,0 +1,43 @@
+#!/bin/bash
+
+# 同時記録のストレステスト
+# 多数の rrk hook record を並行して実行し、全エントリが記録され、IDが重複しないことを確認する
+# 使用法: ./stress-record.sh [並列数] [1プロセスあたりの記録数]
+
+set -e
+
+WORKERS=${1:-20}
+PER_WORKER=${2:-25}
+EXPECTED=$((WORKERS * PER_WORKER))
+
+# 一時的なHOMEで実行し、実際の履歴には触れない
+WORKDIR=$(mktemp -d)
+trap 'rm -rf "$WORKDIR"' EXIT
+
+echo "Building rrk..."
+go build -o "$WORKDIR/rrk" "$(dirname "$0")/.."
+
+export HOME="$WORKDIR/home"
+export RRK_SESSION_ID=stress
+mkdir -p "$HOME"
+
+echo "Recording $EXPECTED commands from $WORKERS concurrent processes..."
+for w in $(seq 1 "$WORKERS"); do
+    (
+        for i in $(seq 1 "$PER_WORKER"); do
+            "$WORKDIR/rrk" hook record --cwd /tmp -- "echo worker-$w-$i"
+        done
+    ) &
+done
+wait
+
+"$WORKDIR/rrk" export > "$WORKDIR/export.jsonl"
+TOTAL=$(wc -l < "$WORKDIR/export.jsonl" | tr -d ' ')
+UNIQUE=$(grep -o '"id":[0-9]*' "$WORKDIR/export.jsonl" | sort -u | wc -l | tr -d ' ')
+
+echo "Entries: $TOTAL, unique IDs: $UNIQUE (expected $EXPECTED)"
+if [ "$TOTAL" != "$EXPECTED" ] || [ "$UNIQUE" != "$EXPECTED" ]; then
+    echo "❌ Stress test failed"
+    exit 1
+fi
+echo "✅ All IDs are unique"